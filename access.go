@@ -0,0 +1,94 @@
+package main
+
+import (
+	"errors"
+	"os/user"
+	"strconv"
+	"sync/atomic"
+	"syscall"
+
+	model "extsvr/model"
+)
+
+// policy 为nil时不做限制
+var policy atomic.Pointer[model.Policy]
+
+// loadPolicy 读取访问策略，格式错误时只允许root和ssdctld自身的用户
+func loadPolicy() {
+	p, err := model.LoadPolicy(policyfile)
+	if err != nil {
+		stdlog.Error("load policy error, deny all but root: " + err.Error())
+	}
+	policy.Store(p)
+}
+
+// jobPerm 各命令需要的权限，为空表示不需要权限
+func jobPerm(j model.Jobs) string {
+	switch j {
+	case model.JobEnd:
+		return ""
+	case model.JobStatus, model.JobList, model.JobPs, model.JobEvents:
+		return model.PermRead
+	case model.JobStart, model.JobStop, model.JobRestart, model.JobEnable, model.JobDisable:
+		return model.PermControl
+	case model.JobAttach, model.JobInput, model.JobDetach:
+		return model.PermConsole
+	case model.JobCreate, model.JobRemove, model.JobUpate, model.JobSetLevel, model.JobEdit:
+		return model.PermConfig
+	default:
+		return model.PermAdmin
+	}
+}
+
+// authorize 根据调用方身份和策略检查权限
+func authorize(cred *syscall.Ucred, todo *model.ToDo) error {
+	p := policy.Load()
+	perm := jobPerm(todo.Do)
+	if p == nil || perm == "" {
+		return nil
+	}
+	if cred == nil {
+		return errors.New("permission denied: unknown caller")
+	}
+	// 针对all等多个服务的操作不属于单个服务
+	service := todo.Name
+	if model.IsReservedName(service) {
+		service = ""
+	}
+	var tags []string
+	if svr, ok := allconf.GetItem(service); ok {
+		tags = svr.Tags
+	}
+	id := identity(cred)
+	if p.Allow(id, perm, service, tags) {
+		return nil
+	}
+	target := "`" + todo.Name + "`"
+	if service == "" {
+		target = "all services"
+	}
+	return errors.New("permission denied: " + id.Users[len(id.Users)-1] + " has no " + perm + " permission on " + target)
+}
+
+// identity 调用方的uid、用户名以及所有组的gid和组名
+func identity(cred *syscall.Ucred) *model.Identity {
+	uid := strconv.Itoa(int(cred.Uid))
+	id := &model.Identity{
+		Uid:    int(cred.Uid),
+		Users:  []string{uid},
+		Groups: []string{strconv.Itoa(int(cred.Gid))},
+	}
+	u, err := user.LookupId(uid)
+	if err != nil {
+		return id
+	}
+	id.Users = append(id.Users, u.Username)
+	gids, _ := u.GroupIds()
+	for _, g := range append(gids, strconv.Itoa(int(cred.Gid))) {
+		id.Groups = append(id.Groups, g)
+		if x, err := user.LookupGroupId(g); err == nil {
+			id.Groups = append(id.Groups, x.Name)
+		}
+	}
+	return id
+}
@@ -0,0 +1,521 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+
+	model "extsvr/model"
+)
+
+// apiResult 启停等操作的结果
+type apiResult struct {
+	Service string `json:"service"`
+	Action  string `json:"action"`
+	OK      bool   `json:"ok"`
+	Message string `json:"message,omitempty"`
+}
+
+type apiError struct {
+	Error  string   `json:"error"`
+	Errors []string `json:"errors,omitempty"` // 配置检查发现的所有问题
+}
+
+// apiCaller 接口调用方，unix socket时通过SO_PEERCRED取得uid/gid，tcp时cred为nil，
+// 设置了访问策略时只有unix socket的调用方能通过authorize
+type apiCaller struct {
+	source string
+	cred   *syscall.Ucred
+}
+
+type apiCallerKey struct{}
+
+// startAPI 启动http/json管理接口，addr为`unix:/path/to.sock`或本机tcp地址，tcp时必须设置token，
+// 并且tcp调用方无法对应到策略中的用户，存在访问策略文件时不启动
+func startAPI(addr, token string) {
+	if !strings.HasPrefix(addr, "unix:") {
+		if token == "" {
+			stdlog.Error("api not started, api_token must be set when listen on tcp")
+			return
+		}
+		if _, err := os.Stat(policyfile); err == nil {
+			stdlog.Error("api not started, only unix socket is allowed when " + policyfile + " exists")
+			return
+		}
+	}
+	ln, err := apiListen(addr)
+	if err != nil {
+		stdlog.Error("api listen error: " + err.Error())
+		return
+	}
+	srv := &http.Server{
+		Handler:     apiGuard(token, newAPIMux()),
+		ConnContext: apiConnContext("api"),
+	}
+	go func() {
+		stdlog.Info("start api server: " + addr)
+		if err := srv.Serve(ln); err != nil {
+			stdlog.Error("api server error: " + err.Error())
+		}
+	}()
+}
+
+// apiListen 接口和管理页面只监听unix socket或本机地址
+func apiListen(addr string) (net.Listener, error) {
+	if path, ok := strings.CutPrefix(addr, "unix:"); ok {
+		os.Remove(path)
+		ln, err := net.Listen("unix", path)
+		if err != nil {
+			return nil, err
+		}
+		os.Chmod(path, 0o660)
+		return ln, nil
+	}
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	if host != "localhost" {
+		ip := net.ParseIP(host)
+		if ip == nil || !ip.IsLoopback() {
+			return nil, errors.New("only listen on unix socket or localhost, got " + addr)
+		}
+	}
+	return net.Listen("tcp", addr)
+}
+
+// apiGuard 设置了token时校验`Authorization: Bearer <token>`，拒绝其他站点页面发起的请求，
+// 修改类请求必须是`Content-Type: application/json`，浏览器跨站提交表单时无法带上
+func apiGuard(token string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if token != "" {
+			if t, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); !ok || !secureEqual(t, token) {
+				writeError(w, http.StatusUnauthorized, "unauthorized")
+				return
+			}
+		}
+		if o := r.Header.Get("Origin"); o != "" {
+			if u, err := url.Parse(o); err != nil || u.Host != r.Host {
+				writeError(w, http.StatusForbidden, "cross origin request is not allowed")
+				return
+			}
+		}
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			if mt, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mt != "application/json" {
+				writeError(w, http.StatusUnsupportedMediaType, "Content-Type must be application/json")
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// apiConnContext 在连接上记录调用方
+func apiConnContext(source string) func(context.Context, net.Conn) context.Context {
+	return func(ctx context.Context, c net.Conn) context.Context {
+		x := &apiCaller{source: source}
+		if uc, ok := c.(*net.UnixConn); ok {
+			x.cred = peerCred(uc)
+		}
+		return context.WithValue(ctx, apiCallerKey{}, x)
+	}
+}
+
+func callerOf(r *http.Request) *apiCaller {
+	if x, ok := r.Context().Value(apiCallerKey{}).(*apiCaller); ok {
+		return x
+	}
+	return &apiCaller{}
+}
+
+// apiAllow 计数并按访问策略检查权限，没有权限时返回403并记录审计日志
+func apiAllow(w http.ResponseWriter, r *http.Request, do model.Jobs, name string) bool {
+	countRequest(do)
+	todo := &model.ToDo{Do: do, Name: name}
+	if err := authorize(callerOf(r).cred, todo); err != nil {
+		auditAPI(r, todo, err.Error())
+		writeError(w, http.StatusForbidden, err.Error())
+		return false
+	}
+	return true
+}
+
+func newAPIMux() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/services", apiListServices)
+	mux.HandleFunc("POST /api/services", apiCreateService)
+	mux.HandleFunc("GET /api/services/{name}", apiGetService)
+	mux.HandleFunc("PUT /api/services/{name}", apiUpdateService)
+	mux.HandleFunc("DELETE /api/services/{name}", apiDeleteService)
+	mux.HandleFunc("POST /api/services/{name}/{action}", apiServiceAction)
+	mux.HandleFunc("GET /api/services/{name}/logs", apiServiceLogs)
+	mux.HandleFunc("GET /api/status", apiStatus)
+	mux.HandleFunc("GET /api/events", apiEvents)
+	mux.HandleFunc("POST /api/reload", apiReload)
+	return mux
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, code int, err string) {
+	writeJSON(w, code, &apiError{Error: err})
+}
+
+// writeInvalid 配置检查没有通过，返回400和所有问题
+func writeInvalid(w http.ResponseWriter, errs []string) {
+	writeJSON(w, http.StatusBadRequest, &apiError{Error: "invalid config: " + strings.Join(errs, "; "), Errors: errs})
+}
+
+func apiListServices(w http.ResponseWriter, r *http.Request) {
+	if !apiAllow(w, r, model.JobList, "") {
+		return
+	}
+	tree := r.URL.Query().Has("tree")
+	ss := make([]*model.ServiceInfo, 0, allconf.Len())
+	allconf.ForEach(func(key string, value *model.ServiceParams) bool {
+		ss = append(ss, &model.ServiceInfo{
+			Name:   key,
+			Config: value.Redacted(),
+			Status: svrStatus(key, value, tree),
+		})
+		return true
+	})
+	writeJSON(w, http.StatusOK, ss)
+}
+
+func apiGetService(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	if !apiAllow(w, r, model.JobList, name) {
+		return
+	}
+	svr, ok := allconf.GetItem(name)
+	if !ok {
+		writeError(w, http.StatusNotFound, "service "+name+" not exist")
+		return
+	}
+	writeJSON(w, http.StatusOK, &model.ServiceInfo{
+		Name:   name,
+		Config: svr.Redacted(),
+		Status: svrStatus(name, svr, r.URL.Query().Has("tree")),
+	})
+}
+
+func apiServiceLogs(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	if !apiAllow(w, r, model.JobList, name) {
+		return
+	}
+	if _, ok := allconf.GetItem(name); !ok {
+		writeError(w, http.StatusNotFound, "service "+name+" not exist")
+		return
+	}
+	writeJSON(w, http.StatusOK, svrLogs(name, 50))
+}
+
+func apiStatus(w http.ResponseWriter, r *http.Request) {
+	if !apiAllow(w, r, model.JobStatus, "") {
+		return
+	}
+	tree := r.URL.Query().Has("tree")
+	ss := make([]*model.ServiceStatus, 0, allconf.Len())
+	allconf.ForEach(func(key string, value *model.ServiceParams) bool {
+		ss = append(ss, svrStatus(key, value, tree))
+		return true
+	})
+	writeJSON(w, http.StatusOK, ss)
+}
+
+func apiCreateService(w http.ResponseWriter, r *http.Request) {
+	x := &struct {
+		Name string `json:"name"`
+		model.ServiceParams
+	}{}
+	x.Enable = true // 和命令行create一致，默认启用
+	if err := json.NewDecoder(r.Body).Decode(x); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if x.Name == "" || x.Exec == "" {
+		writeError(w, http.StatusBadRequest, "name and exec are required")
+		return
+	}
+	if !apiAllow(w, r, model.JobCreate, x.Name) {
+		return
+	}
+	todo := &model.ToDo{Do: model.JobCreate, Name: x.Name, Exec: x.Exec}
+	if errs := model.CheckService(x.Name, &x.ServiceParams, cnfdir); len(errs) > 0 {
+		auditAPI(r, todo, strings.Join(errs, "; "))
+		writeInvalid(w, errs)
+		return
+	}
+	if err := allconf.AddItem(x.Name, &x.ServiceParams); err != nil {
+		auditAPI(r, todo, err.Error())
+		writeError(w, http.StatusConflict, err.Error())
+		return
+	}
+	auditAPI(r, todo, "created")
+	stdlog.Info("add " + x.Name)
+	svr, _ := allconf.GetItem(x.Name)
+	writeJSON(w, http.StatusCreated, &model.ServiceInfo{
+		Name:   x.Name,
+		Config: svr.Redacted(),
+		Status: svrStatus(x.Name, svr, false),
+	})
+}
+
+func apiUpdateService(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	if !apiAllow(w, r, model.JobUpate, name) {
+		return
+	}
+	svr := &model.ServiceParams{}
+	if err := json.NewDecoder(r.Body).Decode(svr); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if svr.Exec == "" {
+		writeError(w, http.StatusBadRequest, "exec is required")
+		return
+	}
+	unlock := lockSvr(name)
+	defer unlock()
+	todo := &model.ToDo{Do: model.JobUpate, Name: name, Exec: svr.Exec}
+	old, ok := allconf.GetItem(name)
+	if !ok {
+		auditAPI(r, todo, "service "+name+" not exist")
+		writeError(w, http.StatusNotFound, "service "+name+" not exist")
+		return
+	}
+	svr.Unredact(old) // GET返回的隐藏值原样提交时保留原来的值
+	if errs := model.CheckService(name, svr, cnfdir); len(errs) > 0 {
+		auditAPI(r, todo, strings.Join(errs, "; "))
+		writeInvalid(w, errs)
+		return
+	}
+	// 和ssdctl edit一样只修改文件中变化的字段，检查后再写入
+	b, err := allconf.UpdateContent(name, svr)
+	if err != nil {
+		auditAPI(r, todo, err.Error())
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	s, ce := allconf.CheckItem(name, b)
+	if s == nil {
+		auditAPI(r, todo, ce.Error())
+		writeInvalid(w, ce.Errors)
+		return
+	}
+	if err := allconf.WriteItem(name, s, b); err != nil {
+		auditAPI(r, todo, err.Error())
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	auditAPI(r, todo, "updated")
+	stdlog.Info("update " + name)
+	svr, _ = allconf.GetItem(name)
+	writeJSON(w, http.StatusOK, &model.ServiceInfo{
+		Name:   name,
+		Config: svr.Redacted(),
+		Status: svrStatus(name, svr, false),
+	})
+}
+
+func apiDeleteService(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	if !apiAllow(w, r, model.JobRemove, name) {
+		return
+	}
+	todo := &model.ToDo{Do: model.JobRemove, Name: name}
+	if err := allconf.DelItem(name); err != nil {
+		auditAPI(r, todo, err.Error())
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	auditAPI(r, todo, "removed")
+	stdlog.Info("remove " + name)
+	writeJSON(w, http.StatusOK, &apiResult{Service: name, Action: model.NameRemove, OK: true})
+}
+
+func apiReload(w http.ResponseWriter, r *http.Request) {
+	if !apiAllow(w, r, model.JobUpate, "") {
+		return
+	}
+	unlock := lockAll()
+	_, errs := reloadConfig(true, func(name, s string, ok bool) {
+		stdlog.Info(s)
+	})
+	unlock()
+	loadPolicy()
+	logConfigErrors(errs)
+	emit(model.NewEvent(model.EventReloaded, "", 0, strconv.Itoa(allconf.Len())+" services"))
+	stdlog.Info("reload config")
+	result := "reloaded " + strconv.Itoa(allconf.Len()) + " services"
+	if len(errs) > 0 {
+		result += ", " + strconv.Itoa(len(errs)) + " config files have errors"
+	}
+	auditAPI(r, &model.ToDo{Do: model.JobUpate}, result)
+	if len(errs) > 0 { // 没有问题的文件已经加载
+		writeJSON(w, http.StatusUnprocessableEntity, map[string]any{
+			"error":  strconv.Itoa(len(errs)) + " config files have errors",
+			"errors": errs,
+		})
+		return
+	}
+	apiListServices(w, r)
+}
+
+// actionJobs /api/services/{name}/{action}支持的操作
+var actionJobs = map[string]model.Jobs{
+	model.NameStart:   model.JobStart,
+	model.NameStop:    model.JobStop,
+	model.NameRestart: model.JobRestart,
+	model.NameEnable:  model.JobEnable,
+	model.NameDisable: model.JobDisable,
+}
+
+func apiServiceAction(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	action := r.PathValue("action")
+	if _, ok := allconf.GetItem(name); !ok && name != model.NameAll {
+		writeError(w, http.StatusNotFound, "service "+name+" not exist")
+		return
+	}
+	do, ok := actionJobs[action]
+	if !ok {
+		writeError(w, http.StatusNotFound, "unknown action `"+action+"`")
+		return
+	}
+	if !apiAllow(w, r, do, name) {
+		return
+	}
+	var rs []*apiResult
+	switch do {
+	case model.JobStart:
+		rs = startAction(name)
+	case model.JobStop:
+		rs = stopAction(name)
+	case model.JobRestart:
+		rs = append(stopAction(name), startAction(name)...)
+	case model.JobEnable, model.JobDisable:
+		if name == model.NameAll {
+			writeError(w, http.StatusBadRequest, "can not "+action+" all")
+			return
+		}
+		err := allconf.SetEnable(name, do == model.JobEnable)
+		rs = []*apiResult{{Service: name, Action: action, OK: err == nil}}
+		if err != nil {
+			rs[0].Message = err.Error()
+		} else {
+			typ := model.EventDisabled
+			if do == model.JobEnable {
+				typ = model.EventEnabled
+			}
+			emit(model.NewEvent(typ, name, 0, ""))
+			stdlog.Info(action + " " + name)
+		}
+	}
+	code := http.StatusOK
+	ss := make([]string, 0, len(rs))
+	for _, x := range rs {
+		if !x.OK {
+			code = http.StatusInternalServerError
+		}
+		ss = append(ss, x.Service+" "+x.Action+" "+map[bool]string{true: "ok", false: "failed"}[x.OK])
+	}
+	auditAPI(r, &model.ToDo{Do: do, Name: name}, strings.Join(ss, "; "))
+	writeJSON(w, code, rs)
+}
+
+// startAction 和命令行start相同，all时启动所有enable的服务
+func startAction(name string) []*apiResult {
+	rs := make([]*apiResult, 0)
+	allconf.ForEach(func(key string, value *model.ServiceParams) bool {
+		if name == model.NameAll && !value.Enable || name != model.NameAll && key != name {
+			return true
+		}
+		s, ok := startSvr(key, value)
+		stdlog.Info(s)
+		rs = append(rs, &apiResult{Service: key, Action: model.NameStart, OK: ok, Message: plainOutput(s)})
+		return true
+	})
+	return rs
+}
+
+// stopAction 和命令行stop相同，all时跳过基础服务
+func stopAction(name string) []*apiResult {
+	rs := make([]*apiResult, 0)
+	allconf.ForEach(func(key string, value *model.ServiceParams) bool {
+		if name == model.NameAll && (!value.Enable || keepOnStopAll(value)) || name != model.NameAll && key != name {
+			return true
+		}
+		s, ok := stopSvr(key, value)
+		stdlog.Warning(s)
+		rs = append(rs, &apiResult{Service: key, Action: model.NameStop, OK: ok, Message: plainOutput(s)})
+		return true
+	})
+	return rs
+}
+
+// svrLogs 最近的日志，console服务返回缓存的终端输出，其他服务返回ssdctld.log中相关的记录
+func svrLogs(name string, n int) []string {
+	var b []byte
+	c, isConsole := getConsole(name)
+	if isConsole {
+		b = c.tail()
+	} else {
+		b = tailFile(filepath.Join(logdir, "ssdctld.log"), 256*1024)
+	}
+	ss := make([]string, 0, n)
+	lines := strings.Split(strings.ReplaceAll(string(b), "\r", ""), "\n")
+	for i := len(lines) - 1; i >= 0 && len(ss) < n; i-- {
+		if lines[i] == "" || !isConsole && !strings.Contains(lines[i], name) {
+			continue
+		}
+		ss = append(ss, lines[i])
+	}
+	slices.Reverse(ss)
+	return ss
+}
+
+// tailFile 读取文件末尾最多size字节
+func tailFile(path string, size int64) []byte {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return nil
+	}
+	off := max(fi.Size()-size, 0)
+	b := make([]byte, fi.Size()-off)
+	n, _ := f.ReadAt(b, off)
+	return b[:n]
+}
+
+// plainOutput 去掉formatOutput添加的标题行
+func plainOutput(s string) string {
+	ss := make([]string, 0)
+	for v := range strings.SplitSeq(s, "\n") {
+		if strings.HasPrefix(v, "[ ") && strings.HasSuffix(v, " ]") {
+			continue
+		}
+		ss = append(ss, v)
+	}
+	return strings.Join(ss, "\n")
+}
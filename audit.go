@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net"
+	"net/http"
+	"os"
+	"os/user"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	model "extsvr/model"
+)
+
+// 审计日志最多返回的记录数
+const auditMaxRecords = 1000
+
+var (
+	auditLocker sync.Mutex
+	auditFile   *os.File
+	auditUsers  = make(map[int]string)
+)
+
+// openAudit 以追加方式打开审计日志，每行一条json记录
+func openAudit() error {
+	f, err := os.OpenFile(filepath.Join(logdir, "audit.log"), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o640)
+	if err != nil {
+		return err
+	}
+	auditFile = f
+	return nil
+}
+
+// setPassCred 开启SO_PASSCRED，每个数据报都会附带发送方的pid/uid/gid
+func setPassCred(conn *net.UnixConn) error {
+	rc, err := conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var serr error
+	err = rc.Control(func(fd uintptr) {
+		serr = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_PASSCRED, 1)
+	})
+	if err != nil {
+		return err
+	}
+	return serr
+}
+
+// parseCred 从控制消息中取出发送方的身份
+func parseCred(oob []byte) *syscall.Ucred {
+	msgs, err := syscall.ParseSocketControlMessage(oob)
+	if err != nil {
+		return nil
+	}
+	for _, m := range msgs {
+		if cred, err := syscall.ParseUnixCredentials(&m); err == nil {
+			return cred
+		}
+	}
+	return nil
+}
+
+func userName(uid int) string {
+	if s, ok := auditUsers[uid]; ok {
+		return s
+	}
+	var s string
+	if u, err := user.LookupId(strconv.Itoa(uid)); err == nil {
+		s = u.Username
+	}
+	auditUsers[uid] = s
+	return s
+}
+
+// audit 记录请求、发送方身份和返回给客户端的结果
+func audit(cli *unixClient, todo *model.ToDo) {
+	if auditFile == nil || cli.audited {
+		return
+	}
+	cli.audited = true
+	if len(todo.Data) > 0 { // edit提交的配置可能包含密码，只记录sha256
+		x := *todo
+		x.Data = nil
+		x.Params = append(slices.Clone(x.Params), "sha256:"+model.ContentSum(todo.Data))
+		todo = &x
+	}
+	r := &model.AuditRecord{
+		Time:   time.Now().Unix(),
+		Uid:    -1,
+		Gid:    -1,
+		Peer:   cli.Name(),
+		ToDo:   todo,
+		Result: cli.result(),
+	}
+	writeAudit(r, cli.cred)
+}
+
+// auditAPI 记录http接口的修改操作和被拒绝的请求，unix socket时有调用方的uid/gid，tcp时记录远端地址
+func auditAPI(r *http.Request, todo *model.ToDo, result string) {
+	if auditFile == nil {
+		return
+	}
+	c := callerOf(r)
+	x := &model.AuditRecord{
+		Time:   time.Now().Unix(),
+		Uid:    -1,
+		Gid:    -1,
+		Source: c.source,
+		ToDo:   todo,
+		Result: result,
+	}
+	if r.RemoteAddr != "@" {
+		x.Peer = r.RemoteAddr
+	}
+	writeAudit(x, c.cred)
+}
+
+func writeAudit(r *model.AuditRecord, cred *syscall.Ucred) {
+	auditLocker.Lock()
+	defer auditLocker.Unlock()
+	if cred != nil {
+		r.Uid, r.Gid, r.Pid = int(cred.Uid), int(cred.Gid), int(cred.Pid)
+		r.User = userName(r.Uid)
+	}
+	b, err := json.Marshal(r)
+	if err != nil {
+		return
+	}
+	if _, err := auditFile.Write(append(b, '\n')); err != nil {
+		stdlog.Error("write audit log error: " + err.Error())
+	}
+}
+
+// auditQuery 返回since(unix秒)之后的审计记录，最多auditMaxRecords条
+func auditQuery(since int64) []*model.AuditRecord {
+	f, err := os.Open(filepath.Join(logdir, "audit.log"))
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	rs := make([]*model.AuditRecord, 0)
+	scan := bufio.NewScanner(f)
+	scan.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scan.Scan() {
+		r := &model.AuditRecord{}
+		if err := json.Unmarshal(scan.Bytes(), r); err != nil || r.Time < since {
+			continue
+		}
+		rs = append(rs, r)
+		if len(rs) > auditMaxRecords {
+			rs = rs[1:]
+		}
+	}
+	return rs
+}
+
+// result 发送给客户端的内容，去掉标题并合并为一行
+func (uc *unixClient) result() string {
+	ss := make([]string, 0)
+	for v := range strings.SplitSeq(plainOutput(uc.out.String()), "\n") {
+		if v = strings.TrimSpace(v); v != "" {
+			ss = append(ss, v)
+		}
+	}
+	s := strings.Join(ss, "; ")
+	if len(s) > 256 {
+		s = s[:256] + "..."
+	}
+	return s
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"extsvr/model"
+
+	gocmd "github.com/xyzj/go-cmd"
+)
+
+const checkHelp = `Usage:
+  check [--dir cnf.d] [file|name ...]
+
+Check program configs without connecting to ssdctld, all files in cnf.d by default.
+
+Flags:
+  --dir	the config dir, default is cnf.d next to ssdctl
+
+Checks:
+  yaml syntax, unknown keys, missing or non-executable exec, env format, env_files,
+  replace entries, reserved names and priority range, after merging _defaults.yaml
+  and extends; files starting with _ are templates, only yaml syntax and keys are checked
+
+Exit status:
+  0 no problem, 2 any file has problems`
+
+// checkConfig 检查配置文件，参数可以是文件路径或服务名
+func checkConfig(params []string) int {
+	dir := gocmd.JoinPathFromHere("cnf.d")
+	files := make([]string, 0)
+	for i := 0; i < len(params); i++ {
+		v := params[i]
+		switch {
+		case v == "--dir" && i+1 < len(params):
+			dir = params[i+1]
+			i++
+		case strings.HasPrefix(v, "--dir="):
+			dir = v[6:]
+		case strings.HasPrefix(v, "-"):
+			println(checkHelp)
+			return model.CodeInvalid
+		default:
+			files = append(files, v)
+		}
+	}
+	if len(files) == 0 {
+		fs, err := filepath.Glob(filepath.Join(dir, "*.yaml"))
+		if err != nil || len(fs) == 0 {
+			println("*** no config file found in " + dir)
+			return model.CodeInvalid
+		}
+		files = fs
+	} else {
+		for i, f := range files {
+			if fi, err := os.Stat(f); err == nil && !fi.IsDir() {
+				continue
+			}
+			files[i] = filepath.Join(dir, f+".yaml")
+		}
+	}
+	// 按目录读取，_defaults.yaml和extends需要同一目录中的其他文件
+	dirs := make(map[string]map[string]*model.ConfigError)
+	code := model.CodeOK
+	for _, f := range files {
+		d, name := filepath.Dir(f), strings.TrimSuffix(filepath.Base(f), ".yaml")
+		if _, err := os.Stat(f); err != nil {
+			code = model.CodeInvalid
+			fmt.Println("[ " + name + ":  CHECK ]\n  " + err.Error())
+			continue
+		}
+		es, ok := dirs[d]
+		if !ok {
+			_, errs, err := model.LoadDir(d, nil)
+			if err != nil {
+				errs = []*model.ConfigError{{File: filepath.Base(f), Errors: []string{err.Error()}}}
+			}
+			es = make(map[string]*model.ConfigError)
+			for _, e := range errs {
+				es[strings.TrimSuffix(e.File, ".yaml")] = e
+			}
+			dirs[d] = es
+		}
+		e, ok := es[name]
+		if !ok {
+			fmt.Println("[ " + name + ":  CHECK ]\n  ok")
+			continue
+		}
+		code = model.CodeInvalid
+		fmt.Println("[ " + name + ":  CHECK ]")
+		for _, s := range e.Errors {
+			fmt.Println("  " + s)
+		}
+	}
+	return code
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"extsvr/model"
+)
+
+const editHelp = `Usage:
+  edit app
+
+Open the config file of app in $VISUAL or $EDITOR (default vi). After saving, the config is
+checked with _defaults.yaml and extends in cnf.d, the diff against the running config and the
+action to take are shown, and ssdctld saves and applies it after confirmation.
+On errors the editor is opened again with the errors on top, save it unchanged to give up.
+The changes are kept in a temporary file if they are not applied.`
+
+// 编辑器中以此开头的行会被去掉
+const editMark = "# ssdctl:"
+
+// edit2svr 类似kubectl edit，编辑、检查、确认后通过ssdctld保存并应用
+func edit2svr(params []string) int {
+	if len(params) != 2 || strings.HasPrefix(params[1], "-") {
+		println(editHelp)
+		return model.CodeInvalid
+	}
+	name := params[1]
+	rs := request(&model.ToDo{Do: model.JobEdit, Name: name})
+	if code := exitCode(rs); code != model.CodeOK || len(rs) == 0 {
+		return max(code, model.CodeFailed)
+	}
+	ed := &model.EditData{}
+	if err := json.Unmarshal(rs[0].Data, ed); err != nil {
+		println("*** " + err.Error())
+		return model.CodeFailed
+	}
+	f, err := os.CreateTemp("", "ssdctl-edit-"+name+"-*.yaml")
+	if err != nil {
+		println("*** " + err.Error())
+		return model.CodeFailed
+	}
+	f.Close()
+	tmp := f.Name()
+	content, last := ed.Content, ""
+	header := []string{
+		"Edit the config of `" + name + "`, lines beginning with '" + editMark + "' are ignored,",
+		"an empty file will abort the edit.",
+	}
+	for {
+		b := strings.Builder{}
+		for _, s := range header {
+			b.WriteString(editMark + " " + s + "\n")
+		}
+		b.WriteString(content)
+		if err := os.WriteFile(tmp, []byte(b.String()), 0o600); err != nil {
+			println("*** " + err.Error())
+			return model.CodeFailed
+		}
+		if err := runEditor(tmp); err != nil {
+			println("*** " + err.Error() + ", your changes are saved in " + tmp)
+			return model.CodeFailed
+		}
+		x, err := os.ReadFile(tmp)
+		if err != nil {
+			println("*** " + err.Error())
+			return model.CodeFailed
+		}
+		content = stripEditMark(string(x))
+		switch {
+		case strings.TrimSpace(content) == "":
+			os.Remove(tmp)
+			println("edit cancelled, the file is empty")
+			return model.CodeOK
+		case content == ed.Content:
+			os.Remove(tmp)
+			println("edit cancelled, no changes made")
+			return model.CodeOK
+		case content == last: // 有错误时没有再修改
+			println("edit cancelled, no valid changes were saved, your changes are saved in " + tmp)
+			return model.CodeInvalid
+		}
+		rs = request(&model.ToDo{Do: model.JobEdit, Name: name, Params: []string{"--dry-run"}, Data: []byte(content)})
+		code := exitCode(rs)
+		if code == model.CodeOK {
+			break
+		}
+		if code != model.CodeInvalid {
+			println("your changes are saved in " + tmp)
+			return code
+		}
+		last = content
+		header = []string{"The edited config is invalid:"}
+		for _, r := range rs {
+			ce := &model.ConfigError{}
+			if json.Unmarshal(r.Data, ce) == nil {
+				for _, s := range ce.Errors {
+					header = append(header, "  "+s)
+				}
+			}
+		}
+	}
+	fmt.Print("apply the changes to `" + name + "`? [y/N] ")
+	s, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	if s = strings.ToLower(strings.TrimSpace(s)); s != "y" && s != "yes" {
+		println("not applied, your changes are saved in " + tmp)
+		return model.CodeOK
+	}
+	rs = request(&model.ToDo{Do: model.JobEdit, Name: name, Params: []string{"--apply", ed.Sum}, Data: []byte(content)})
+	if code := exitCode(rs); code != model.CodeOK {
+		println("your changes are saved in " + tmp)
+		return code
+	}
+	os.Remove(tmp)
+	return model.CodeOK
+}
+
+// runEditor $VISUAL和$EDITOR可以带参数，通过sh执行
+func runEditor(path string) error {
+	editor := os.Getenv("VISUAL")
+	if editor == "" {
+		editor = os.Getenv("EDITOR")
+	}
+	if editor == "" {
+		editor = "vi"
+	}
+	cmd := exec.Command("sh", "-c", editor+` "$1"`, "sh", path)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	return cmd.Run()
+}
+
+// stripEditMark 去掉编辑器中的提示行
+func stripEditMark(s string) string {
+	ls := strings.SplitAfter(s, "\n")
+	out := ls[:0]
+	for _, l := range ls {
+		if !strings.HasPrefix(l, editMark) {
+			out = append(out, l)
+		}
+	}
+	return strings.Join(out, "")
+}
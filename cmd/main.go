@@ -2,11 +2,16 @@ package main
 
 import (
 	"bufio"
+	"bytes"
+	"encoding/json"
 	"fmt"
 	"net"
 	"os"
+	"os/signal"
+	"strconv"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
 
 	"extsvr/model"
@@ -15,9 +20,11 @@ import (
 )
 
 var (
-	version   = "0.0.0"
-	clilocker = sync.WaitGroup{}
-	cliConn   *net.UnixConn
+	version    = "0.0.0"
+	cliConn    *net.UnixConn
+	cliReader  *bufio.Reader
+	sendLocker sync.Mutex
+	reqID      uint64
 )
 
 // 接收消息格式： fmt.Sprintf("%d|%s|%s|%s|",do,name,exec,params)
@@ -35,48 +42,42 @@ func main() {
 			Name:     "start",
 			Descript: "start a program",
 			RunWithExitCode: func(pi *gocmd.ProcInfo) int {
-				send2svr(os.Args[1:]...)
-				return 0
+				return send2svr(os.Args[1:]...)
 			},
 		}).
 		AddCommand(&gocmd.Command{
 			Name:     "stop",
 			Descript: "stop the program",
 			RunWithExitCode: func(pi *gocmd.ProcInfo) int {
-				send2svr(os.Args[1:]...)
-				return 0
+				return send2svr(os.Args[1:]...)
 			},
 		}).
 		AddCommand(&gocmd.Command{
 			Name:     "restart",
 			Descript: "restart the program",
 			RunWithExitCode: func(pi *gocmd.ProcInfo) int {
-				send2svr(os.Args[1:]...)
-				return 0
+				return send2svr(os.Args[1:]...)
 			},
 		}).
 		AddCommand(&gocmd.Command{
 			Name:     "enable",
 			Descript: "set a program to autorun",
 			RunWithExitCode: func(pi *gocmd.ProcInfo) int {
-				send2svr(os.Args[1:]...)
-				return 0
+				return send2svr(os.Args[1:]...)
 			},
 		}).
 		AddCommand(&gocmd.Command{
 			Name:     "disable",
 			Descript: "set a program not to autorun",
 			RunWithExitCode: func(pi *gocmd.ProcInfo) int {
-				send2svr(os.Args[1:]...)
-				return 0
+				return send2svr(os.Args[1:]...)
 			},
 		}).
 		AddCommand(&gocmd.Command{
 			Name:     "remove",
 			Descript: "remove a program config from cnf.d",
 			RunWithExitCode: func(pi *gocmd.ProcInfo) int {
-				send2svr(os.Args[1:]...)
-				return 0
+				return send2svr(os.Args[1:]...)
 			},
 		}).
 		AddCommand(&gocmd.Command{
@@ -84,32 +85,47 @@ func main() {
 			Descript: "add a program config to cnf.d",
 			HelpMsg:  "Usage:\n\t " + os.Args[0] + " create appname execpath param1 param2 ...",
 			RunWithExitCode: func(pi *gocmd.ProcInfo) int {
-				send2svr(os.Args[1:]...)
-				return 0
+				return send2svr(os.Args[1:]...)
 			},
 		}).
 		AddCommand(&gocmd.Command{
 			Name:     "status",
 			Descript: "check the status of a program",
 			HelpMsg: `Usage:
-  status [params...]
+  status [params...] [-o json|yaml|table|wide]
 
 Available commands:
   running	show all running programs status
   enable	show all enabled programs status
   disable	show all disabled programs status
   all		show all enabled programs status
-  [name]	show [name] status`,
+  [name]	show [name] status
+
+Flags:
+  --json	same as -o json
+  --tree	show the process tree of the program
+
+Exit status:
+  0 running, 3 not running, 4 unknown program or error
+
+` + outputHelp,
 			RunWithExitCode: func(pi *gocmd.ProcInfo) int {
-				send2svr(os.Args[1:]...)
-				return 0
+				return send2svr(os.Args[1:]...)
+			},
+		}).
+		AddCommand(&gocmd.Command{
+			Name:     "ps",
+			Descript: "show the process tree of a program",
+			HelpMsg:  "Usage:\n\t " + os.Args[0] + " ps app",
+			RunWithExitCode: func(pi *gocmd.ProcInfo) int {
+				return send2svr(os.Args[1:]...)
 			},
 		}).
 		AddCommand(&gocmd.Command{
 			Name:     "list",
 			Descript: "list program config and status",
 			HelpMsg: `Usage:
-  list [params...]
+  list [params...] [--effective] [-o json|yaml|table|wide]
 
 Available commands:
   enable	list all enabled programs
@@ -117,26 +133,120 @@ Available commands:
   stopped	list all enabled but manual stopped programs
   all		show all programs
   [name]	list [name] process config and status
-  [nothing]	list all programs configured`,
+  [nothing]	list all programs configured
+
+Flags:
+  --effective	show the config after merging _defaults.yaml and extends,
+		instead of the config file as written
+
+` + outputHelp,
 			RunWithExitCode: func(pi *gocmd.ProcInfo) int {
-				send2svr(os.Args[1:]...)
-				return 0
+				return send2svr(os.Args[1:]...)
 			},
 		}).
 		AddCommand(&gocmd.Command{
 			Name:     "update",
 			Descript: "reload all program config in cnf.d",
+			HelpMsg: `Usage:
+  update [--dry-run]
+
+Reload cnf.d, start new, stop removed and restart changed programs according to
+the reload settings and on_config_change.
+
+Flags:
+  --dry-run	show the diff of each changed program and the actions to take, without applying`,
 			RunWithExitCode: func(pi *gocmd.ProcInfo) int {
-				send2svr(os.Args[1:]...)
-				return 0
+				return send2svr(os.Args[1:]...)
 			},
 		}).
 		AddCommand(&gocmd.Command{
 			Name:     "setlevel",
 			Descript: "set a program's start level, 1-255",
 			RunWithExitCode: func(pi *gocmd.ProcInfo) int {
-				send2svr(os.Args[1:]...)
-				return 0
+				return send2svr(os.Args[1:]...)
+			},
+		}).
+		AddCommand(&gocmd.Command{
+			Name:     "attach",
+			Descript: "attach to a console program, press ctrl+] to detach",
+			HelpMsg:  "Usage:\n\t " + os.Args[0] + " attach app",
+			RunWithExitCode: func(pi *gocmd.ProcInfo) int {
+				if len(os.Args) < 3 {
+					println("Usage:\n\t " + os.Args[0] + " attach app")
+					return 1
+				}
+				return attach2svr(os.Args[2])
+			},
+		}).
+		AddCommand(&gocmd.Command{
+			Name:     "events",
+			Descript: "follow the state change events of programs",
+			HelpMsg: `Usage:
+  events [--service app] [--json]
+
+Flags:
+  --service app	only show events of app
+  --json	print one json object per event`,
+			RunWithExitCode: func(pi *gocmd.ProcInfo) int {
+				return events2svr(os.Args[2:])
+			},
+		}).
+		AddCommand(&gocmd.Command{
+			Name:     "audit",
+			Descript: "show the audit log of control commands",
+			HelpMsg: `Usage:
+  audit [--since 1h] [--json]
+
+Flags:
+  --since	only show records after the time, e.g. 30m, 2h, 7d, 2006-01-02 or "2006-01-02 15:04:05"
+  --json	print one json object per record`,
+			RunWithExitCode: func(pi *gocmd.ProcInfo) int {
+				return send2svr(os.Args[1:]...)
+			},
+		}).
+		AddCommand(&gocmd.Command{
+			Name:     "shutdown",
+			Descript: "exit ssdctld, leave programs running by default",
+			HelpMsg: `Usage:
+  shutdown [--stop-services|--leave-running]
+
+Flags:
+  --stop-services	stop all programs from the largest start level to the smallest before exit,
+			they will be started again when ssdctld starts
+  --leave-running	keep programs running, ssdctld finds them by pid files after restart (default),
+			console programs lose their terminal and get SIGHUP, use 'daemon reexec' to keep them.
+			SIGTERM and SIGINT to ssdctld do the same as --leave-running`,
+			RunWithExitCode: func(pi *gocmd.ProcInfo) int {
+				return send2svr(os.Args[1:]...)
+			},
+		}).
+		AddCommand(&gocmd.Command{
+			Name:     "check",
+			Descript: "check program configs, works without ssdctld",
+			HelpMsg:  checkHelp,
+			RunWithExitCode: func(pi *gocmd.ProcInfo) int {
+				return checkConfig(os.Args[2:])
+			},
+		}).
+		AddCommand(&gocmd.Command{
+			Name:     "edit",
+			Descript: "edit a program config in $EDITOR, check and apply it",
+			HelpMsg:  editHelp,
+			RunWithExitCode: func(pi *gocmd.ProcInfo) int {
+				return send2svr(os.Args[1:]...)
+			},
+		}).
+		AddCommand(&gocmd.Command{
+			Name:     "daemon",
+			Descript: "manage ssdctld itself",
+			HelpMsg: `Usage:
+  daemon reexec
+
+Available commands:
+  reexec	re-execute the ssdctld binary in place to upgrade it, keeps the pid, sockets and consoles,
+		programs keep running and are supervised by the new binary`,
+			RunWithExitCode: func(pi *gocmd.ProcInfo) int {
+				return send2svr(os.Args[1:]...)
 			},
 		}).
 		AddCommand(&gocmd.Command{
@@ -149,57 +259,157 @@ Available commands:
 		}).
 		ExecuteNotParseFlag("shell")
 }
+
+// conn2svr 连接ssdctld的流协议socket
 func conn2svr() error {
 	var err error
-	pid := os.Getpid()
-	// 连接unix socket
-	cliConn, err = net.ListenUnixgram("unixgram", model.CliAddr(pid))
+	cliConn, err = net.DialUnix("unix", nil, model.StreamAddr)
+	return err
+}
+
+// sendTodo 发送请求，返回请求序号
+func sendTodo(conn *net.UnixConn, todo *model.ToDo) (uint64, error) {
+	sendLocker.Lock()
+	defer sendLocker.Unlock()
+	reqID++
+	todo.ID = reqID
+	return reqID, model.WriteFrame(conn, todo)
+}
+
+// readFrame 读取一个回复帧
+func readFrame(r *bufio.Reader) (*model.Frame, error) {
+	b, err := model.ReadFrame(r)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	clilocker.Go(func() {
-		buf := make([]byte, 4096)
-		for {
-			// conn.SetReadDeadline(time.Now().Add(time.Second * 2))
-			n, _, err := cliConn.ReadFromUnix(buf)
-			if err != nil {
-				// if strings.Contains(err.Error(), net.ErrClosed.Error()) ||
-				// 	strings.Contains(err.Error(), "connection reset by peer") ||
-				// 	strings.Contains(err.Error(), "EOF") { // err == io.EOF ||
-				// 	return
-				// }
-				println(err.Error())
-				// os.Exit(1)
-				return
+	f := &model.Frame{}
+	return f, json.Unmarshal(b, f)
+}
+
+// request 发送请求并打印输出，直到收到该请求的完成帧，返回结构化的结果
+func request(todo *model.ToDo) []*model.Response {
+	rs := make([]*model.Response, 0)
+	id, err := sendTodo(cliConn, todo)
+	if err != nil {
+		println(err.Error())
+		return append(rs, &model.Response{Code: model.CodeFailed, Message: err.Error()})
+	}
+	for {
+		f, err := readFrame(cliReader)
+		if err != nil {
+			println(err.Error())
+			return append(rs, &model.Response{Code: model.CodeFailed, Message: err.Error()})
+		}
+		if f.ID != id {
+			continue
+		}
+		switch f.Kind {
+		case model.FrameDone:
+			return rs
+		case model.FrameResponse:
+			if f.Response != nil {
+				rs = append(rs, f.Response)
 			}
-			if s := string(buf[:n]); s == "END" {
-				// os.Exit(0)
-				return
-			} else {
-				println(s)
+		default:
+			if outputMode == "" {
+				println(string(f.Data))
 			}
 		}
-	})
-	return nil
+	}
+}
+
+// reexec2svr ssdctld重新执行时连接随之关闭，之后重新连接确认新的进程已经开始处理请求
+func reexec2svr() int {
+	rs := make([]*model.Response, 0)
+	id, err := sendTodo(cliConn, &model.ToDo{Do: model.JobReexec})
+	if err != nil {
+		println(err.Error())
+		return model.CodeFailed
+	}
+	for {
+		f, err := readFrame(cliReader)
+		if err != nil {
+			break
+		}
+		if f.ID != id {
+			continue
+		}
+		switch f.Kind {
+		case model.FrameDone: // 没有重新执行
+			if code := exitCode(rs); code != model.CodeOK {
+				return code
+			}
+			return model.CodeFailed
+		case model.FrameResponse:
+			if f.Response != nil {
+				rs = append(rs, f.Response)
+			}
+		default:
+			println(string(f.Data))
+		}
+	}
+	for range 50 {
+		time.Sleep(time.Millisecond * 200)
+		if conn2svr() != nil {
+			continue
+		}
+		cliReader = bufio.NewReader(cliConn)
+		// end不做任何操作，只用于确认收到回复
+		if _, err := sendTodo(cliConn, &model.ToDo{Do: model.JobEnd}); err == nil {
+			if _, err := readFrame(cliReader); err == nil {
+				println("  ssdctld is running again")
+				return model.CodeOK
+			}
+		}
+		cliConn.Close()
+	}
+	println("*** ssdctld did not come back in 10s, check the log")
+	return model.CodeFailed
+}
+
+// exitCode 第一个失败的结果码，都成功时为0
+func exitCode(rs []*model.Response) int {
+	for _, r := range rs {
+		if !r.OK {
+			return max(r.Code, model.CodeFailed)
+		}
+	}
+	return model.CodeOK
+}
+
+// statusCode LSB status的退出码，0运行中，3停止，4未知，多个服务时取最大值
+func statusCode(rs []*model.Response) int {
+	if len(rs) == 0 {
+		return model.StatusUnknown
+	}
+	code := model.CodeOK
+	for _, r := range rs {
+		switch r.Code {
+		case model.CodeOK:
+		case model.StatusStopped:
+			code = max(code, model.StatusStopped)
+		default:
+			code = model.StatusUnknown
+		}
+	}
+	return code
 }
 
-func send2svr(params ...string) {
+func send2svr(params ...string) int {
 	if !checkParams(params) {
-		return
+		return model.CodeInvalid
 	}
 	err := conn2svr()
 	if err != nil {
 		println(err.Error())
-		return
+		if len(params) > 0 && params[0] == model.NameStatus {
+			return model.StatusUnknown
+		}
+		return model.CodeFailed
 	}
 	defer cliConn.Close()
-	doJob(params)
-	time.Sleep(time.Millisecond * 200)
-	clo := &model.ToDo{
-		Do: model.JobEnd,
-	}
-	cliConn.WriteToUnix(clo.ToJSON(), model.SvrAddr)
-	clilocker.Wait()
+	cliReader = bufio.NewReader(cliConn)
+	return doJob(params)
 }
 
 func shell2svr() {
@@ -215,14 +425,21 @@ Commands:
   restart app1 app2 ...                restart one or more programs
   enable app1 app2 ...                 enable autorun for programs
   disable app1 app2 ...                disable autorun for programs
-  status app|running|enable|disable|all
+  status app|running|enable|disable|all [--tree] [-o json|yaml|table|wide]
                                        query status
-  list [name|enable|disable|stopped|all]
+  ps app                               show the process tree of a program
+  list [name|enable|disable|stopped|all] [--effective] [-o json|yaml|table|wide]
                                        list program config/status
   remove app                           remove one program config
   create app execpath [param1 ...]     add one program config
-  update                               reload/update config in daemon
-  setlevel app level(1-255)            set start level for one app`)
+  update [--dry-run]                   reload/update config in daemon
+  check [file|name ...]                check program configs
+  edit app                             edit a program config, check and apply it
+  setlevel app level(1-255)            set start level for one app
+  audit [--since 1h] [--json]          show the audit log of control commands
+  shutdown [--stop-services|--leave-running]
+                                       exit ssdctld, leave programs running by default
+  daemon reexec                        re-execute ssdctld in place, programs keep running`)
 	}
 	err := conn2svr()
 	if err != nil {
@@ -230,6 +447,7 @@ Commands:
 		return
 	}
 	defer cliConn.Close()
+	cliReader = bufio.NewReader(cliConn)
 	fmt.Println("ssdctl interactive shell, input 'help' to show commands, 'exit' or 'quit' to quit")
 	scanner := bufio.NewScanner(os.Stdin)
 	for {
@@ -252,9 +470,6 @@ Commands:
 			printHelp()
 			continue
 		case "exit", "quit":
-			clo := &model.ToDo{Do: model.JobEnd}
-			cliConn.WriteToUnix(clo.ToJSON(), model.SvrAddr)
-			clilocker.Wait()
 			return
 		}
 
@@ -267,9 +482,141 @@ Commands:
 	if err := scanner.Err(); err != nil {
 		println(err.Error())
 	}
-	clo := &model.ToDo{Do: model.JobEnd}
-	cliConn.WriteToUnix(clo.ToJSON(), model.SvrAddr)
-	clilocker.Wait()
+}
+
+// attach2svr 将当前终端转发到服务的伪终端，ctrl+]断开
+func attach2svr(name string) int {
+	conn, err := net.DialUnix("unix", nil, model.StreamAddr)
+	if err != nil {
+		println(err.Error())
+		return 1
+	}
+	defer conn.Close()
+	fd := os.Stdin.Fd()
+	winsize := func() []string {
+		rows, cols, err := model.GetWinsize(fd)
+		if err != nil {
+			return nil
+		}
+		return []string{strconv.Itoa(int(rows)), strconv.Itoa(int(cols))}
+	}
+	todo := &model.ToDo{
+		Name:   name,
+		Do:     model.JobAttach,
+		Params: winsize(),
+	}
+	id, err := sendTodo(conn, todo)
+	if err != nil {
+		println(err.Error())
+		return 1
+	}
+	if restore, err := model.MakeRaw(fd); err == nil {
+		defer restore()
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		r := bufio.NewReader(conn)
+		for {
+			f, err := readFrame(r)
+			if err != nil {
+				return
+			}
+			if f.ID != id { // 输入等请求的完成帧
+				continue
+			}
+			if f.Kind == model.FrameDone {
+				return
+			}
+			os.Stdout.Write(f.Data)
+		}
+	}()
+	sigwinch := make(chan os.Signal, 1)
+	signal.Notify(sigwinch, syscall.SIGWINCH)
+	defer signal.Stop(sigwinch)
+	go func() {
+		for range sigwinch {
+			x := &model.ToDo{Name: name, Do: model.JobInput, Params: winsize()}
+			sendTodo(conn, x)
+		}
+	}()
+	go func() {
+		buf := make([]byte, 1024)
+		for {
+			n, err := os.Stdin.Read(buf)
+			if err != nil {
+				break
+			}
+			b := buf[:n]
+			i := bytes.IndexByte(b, model.DetachKey)
+			if i >= 0 {
+				b = b[:i]
+			}
+			if len(b) > 0 {
+				x := &model.ToDo{Name: name, Do: model.JobInput, Data: b}
+				sendTodo(conn, x)
+			}
+			if i >= 0 {
+				break
+			}
+		}
+		x := &model.ToDo{Name: name, Do: model.JobDetach}
+		sendTodo(conn, x)
+	}()
+	<-done
+	fmt.Print("\r\n")
+	return 0
+}
+
+// events2svr 订阅并打印事件，直到ctrl+c
+func events2svr(params []string) int {
+	params, js := cutFlag(params, "--json")
+	var service string
+	for i := 0; i < len(params); i++ {
+		if params[i] == "--service" && i+1 < len(params) {
+			service = params[i+1]
+			i++
+		} else if v, ok := strings.CutPrefix(params[i], "--service="); ok {
+			service = v
+		}
+	}
+	conn, err := net.DialUnix("unix", nil, model.StreamAddr)
+	if err != nil {
+		println(err.Error())
+		return 1
+	}
+	defer conn.Close()
+	todo := &model.ToDo{
+		Name: service,
+		Do:   model.JobEvents,
+	}
+	if _, err := sendTodo(conn, todo); err != nil {
+		println(err.Error())
+		return 1
+	}
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sig
+		conn.Close() // 断开连接即取消订阅
+	}()
+	r := bufio.NewReader(conn)
+	for {
+		f, err := readFrame(r)
+		if err != nil || f.Kind == model.FrameDone {
+			return 0
+		}
+		if js {
+			fmt.Println(string(f.Data))
+			continue
+		}
+		ev := &model.Event{}
+		if err := json.Unmarshal(f.Data, ev); err != nil {
+			fmt.Println(string(f.Data))
+			continue
+		}
+		fmt.Println(ev.String())
+	}
 }
 
 func checkParams(params []string) bool {
@@ -284,7 +631,7 @@ func checkParams(params []string) bool {
 			println("Usage:\n\t " + os.Args[0] + " " + cmd + " app1 app2 ...")
 			return false
 		}
-	case model.NameStatus, model.NameRemove:
+	case model.NameStatus, model.NameRemove, model.NamePs:
 		if len(params) < 2 {
 			println("Usage:\n\t " + os.Args[0] + " " + cmd + " app")
 			return false
@@ -294,10 +641,63 @@ func checkParams(params []string) bool {
 			println("Usage:\n\t " + os.Args[0] + " create appname execpath param1 param2 ...")
 			return false
 		}
+	case model.NameDaemon:
+		if len(params) != 2 || params[1] != model.NameReexec {
+			println("Usage:\n\t " + os.Args[0] + " daemon reexec")
+			return false
+		}
 	}
 	return true
 }
-func doJob(params []string) {
+
+// parseSince 支持时长(30m, 2h, 7d)或日期时间
+func parseSince(s string) (time.Time, error) {
+	if d, ok := strings.CutSuffix(s, "d"); ok {
+		if n, err := strconv.Atoi(d); err == nil {
+			return time.Now().AddDate(0, 0, -n), nil
+		}
+	}
+	if d, err := time.ParseDuration(s); err == nil {
+		return time.Now().Add(-d), nil
+	}
+	for _, layout := range []string{time.RFC3339, "2006-01-02 15:04:05", "2006-01-02 15:04", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid time `%s`, use 30m, 2h, 7d or 2006-01-02 15:04:05", s)
+}
+
+// cutFlag 从参数中去掉flag，返回是否存在
+func cutFlag(params []string, flag string) ([]string, bool) {
+	out := make([]string, 0, len(params))
+	found := false
+	for _, v := range params {
+		if v == flag {
+			found = true
+			continue
+		}
+		out = append(out, v)
+	}
+	return out, found
+}
+
+func doJob(params []string) int {
+	rs := make([]*model.Response, 0)
+	var err error
+	params, outputMode, err = cutOutput(params)
+	if err != nil {
+		println(err.Error())
+		return model.CodeInvalid
+	}
+	if len(params) == 0 { // 只有-o时
+		println("missing command, input 'help' to show commands")
+		return model.CodeInvalid
+	}
+	if outputMode != "" && params[0] != model.NameList && params[0] != model.NameStatus {
+		println("-o only works with list and status")
+		return model.CodeInvalid
+	}
 	// 处理命令
 	switch cmd := params[0]; cmd {
 	case model.NameStart:
@@ -306,8 +706,7 @@ func doJob(params []string) {
 				Name: v,
 				Do:   model.JobStart,
 			}
-			cliConn.WriteToUnix(todo.ToJSON(), model.SvrAddr)
-			time.Sleep(time.Millisecond * 200)
+			rs = append(rs, request(todo)...)
 		}
 	case model.NameStop:
 		for _, v := range params[1:] {
@@ -315,8 +714,7 @@ func doJob(params []string) {
 				Name: v,
 				Do:   model.JobStop,
 			}
-			cliConn.WriteToUnix(todo.ToJSON(), model.SvrAddr)
-			time.Sleep(time.Millisecond * 200)
+			rs = append(rs, request(todo)...)
 		}
 	case model.NameRestart:
 		for _, v := range params[1:] {
@@ -324,14 +722,12 @@ func doJob(params []string) {
 				Name: v,
 				Do:   model.JobStop,
 			}
-			cliConn.WriteToUnix(todo.ToJSON(), model.SvrAddr)
-			time.Sleep(time.Millisecond * 200)
+			rs = append(rs, request(todo)...)
 			todo = &model.ToDo{
 				Name: v,
 				Do:   model.JobStart,
 			}
-			cliConn.WriteToUnix(todo.ToJSON(), model.SvrAddr)
-			time.Sleep(time.Millisecond * 200)
+			rs = append(rs, request(todo)...)
 		}
 	case model.NameEnable:
 		for _, v := range params[1:] {
@@ -339,8 +735,7 @@ func doJob(params []string) {
 				Name: v,
 				Do:   model.JobEnable,
 			}
-			cliConn.WriteToUnix(todo.ToJSON(), model.SvrAddr)
-			time.Sleep(time.Millisecond * 200)
+			rs = append(rs, request(todo)...)
 		}
 	case model.NameDisable:
 		for _, v := range params[1:] {
@@ -348,23 +743,32 @@ func doJob(params []string) {
 				Name: v,
 				Do:   model.JobDisable,
 			}
-			cliConn.WriteToUnix(todo.ToJSON(), model.SvrAddr)
-			time.Sleep(time.Millisecond * 200)
+			rs = append(rs, request(todo)...)
 		}
 	case model.NameStatus:
+		params, tree := cutFlag(params, "--tree")
+		if len(params) < 2 {
+			println("Usage:\n\t " + os.Args[0] + " status app [--tree] [-o json|yaml|table|wide]")
+			return model.StatusUnknown
+		}
 		todo := &model.ToDo{
 			Name: params[1],
 			Do:   model.JobStatus,
+			Tree: tree,
 		}
-		cliConn.WriteToUnix(todo.ToJSON(), model.SvrAddr)
-		time.Sleep(time.Millisecond * 200)
+		rs = append(rs, request(todo)...)
+	case model.NamePs:
+		todo := &model.ToDo{
+			Name: params[1],
+			Do:   model.JobPs,
+		}
+		rs = append(rs, request(todo)...)
 	case model.NameRemove:
 		todo := &model.ToDo{
 			Name: params[1],
 			Do:   model.JobRemove,
 		}
-		cliConn.WriteToUnix(todo.ToJSON(), model.SvrAddr)
-		time.Sleep(time.Millisecond * 200)
+		rs = append(rs, request(todo)...)
 	case model.NameCreate:
 		todo := &model.ToDo{
 			Name:   params[1],
@@ -372,10 +776,13 @@ func doJob(params []string) {
 			Exec:   params[2],
 			Params: params[3:],
 		}
-		cliConn.WriteToUnix(todo.ToJSON(), model.SvrAddr)
-		time.Sleep(time.Millisecond * 200)
+		rs = append(rs, request(todo)...)
 	case model.NameList:
 		var todo *model.ToDo
+		params, effective := cutFlag(params, "--effective")
+		if len(params) == 1 && outputMode != "" { // 整个配置文件无法按格式输出，改为列出所有
+			params = append(params, model.NameAll)
+		}
 		if len(params) > 1 {
 			todo = &model.ToDo{
 				Do:   model.JobList,
@@ -386,29 +793,88 @@ func doJob(params []string) {
 				Do: model.JobList,
 			}
 		}
-		cliConn.WriteToUnix(todo.ToJSON(), model.SvrAddr)
-		time.Sleep(time.Millisecond * 200)
+		if effective {
+			todo.Params = []string{"--effective"}
+		}
+		rs = append(rs, request(todo)...)
 	case model.NameUpdate:
+		params, dry := cutFlag(params, "--dry-run")
+		if len(params) > 1 {
+			println("Usage:\n\t " + os.Args[0] + " update [--dry-run]")
+			return model.CodeInvalid
+		}
 		todo := &model.ToDo{
 			Do: model.JobUpate,
 		}
-		cliConn.WriteToUnix(todo.ToJSON(), model.SvrAddr)
-		time.Sleep(time.Millisecond * 200)
+		if dry {
+			todo.Params = []string{"--dry-run"}
+		}
+		rs = append(rs, request(todo)...)
+	case model.NameAudit:
+		params, js := cutFlag(params, "--json")
+		var since int64
+		for i := 1; i < len(params); i++ {
+			v, ok := strings.CutPrefix(params[i], "--since=")
+			if !ok && params[i] == "--since" && i+1 < len(params) {
+				v, ok = params[i+1], true
+				i++
+			}
+			if !ok {
+				continue
+			}
+			t, err := parseSince(v)
+			if err != nil {
+				println(err.Error())
+				return model.CodeInvalid
+			}
+			since = t.Unix()
+		}
+		todo := &model.ToDo{
+			Do:   model.JobAudit,
+			Exec: strconv.FormatInt(since, 10),
+		}
+		if js {
+			todo.Format = model.FormatJSON
+		}
+		rs = append(rs, request(todo)...)
 	case model.NameShutdown:
+		params, stop := cutFlag(params, "--stop-services")
+		params, leave := cutFlag(params, "--leave-running")
+		if stop && leave || len(params) > 1 {
+			println("Usage:\n\t " + os.Args[0] + " shutdown [--stop-services|--leave-running]")
+			return model.CodeInvalid
+		}
 		todo := &model.ToDo{
 			Do: model.JobShutdown,
 		}
-		cliConn.WriteToUnix(todo.ToJSON(), model.SvrAddr)
-		time.Sleep(time.Millisecond * 200)
+		if stop {
+			todo.Params = []string{"--stop-services"}
+		}
+		rs = append(rs, request(todo)...)
+	case model.NameDaemon:
+		return reexec2svr()
+	case model.NameCheck:
+		return checkConfig(params[1:])
+	case model.NameEdit:
+		return edit2svr(params)
 	case model.NameStartLevel:
 		todo := &model.ToDo{
 			Name: params[1],
 			Do:   model.JobSetLevel,
 			Exec: params[2],
 		}
-		cliConn.WriteToUnix(todo.ToJSON(), model.SvrAddr)
-		time.Sleep(time.Millisecond * 200)
+		rs = append(rs, request(todo)...)
 	default:
 		fmt.Printf("unknown command: %s, input 'help' to show commands\n", cmd)
+		return model.CodeInvalid
+	}
+	if outputMode != "" {
+		if err := render(outputMode, rs); err != nil {
+			println(err.Error())
+		}
+	}
+	if params[0] == model.NameStatus {
+		return statusCode(rs)
 	}
+	return exitCode(rs)
 }
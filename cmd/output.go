@@ -0,0 +1,181 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"extsvr/model"
+
+	"gopkg.in/yaml.v3"
+)
+
+// -o 支持的输出格式
+const (
+	outputJSON  = "json"
+	outputYAML  = "yaml"
+	outputTable = "table"
+	outputWide  = "wide"
+)
+
+// outputMode 不为空时不打印daemon的文本输出，改为按格式输出结构化结果
+var outputMode string
+
+const outputHelp = `Output:
+  -o json	a json array, status: [{name, running, enable, priority, manual_stop, restarts,
+		fatal, last_exit_code, last_exit_time, usage: {pid, procs, state, cpu_percent,
+		cpu_seconds, rss_bytes, threads, fds, start_time, uptime_seconds}, tree}],
+		list: [{name, config: {exec, dir, params, ...}, status: {...}}]
+  -o yaml	same as json, in yaml
+  -o table	NAME, STATE, PID, UPTIME, PRIORITY, ENABLED, RESTARTS
+  -o wide	table with CPU, RSS, THREADS, FDS, LAST EXIT and EXEC`
+
+// cutOutput 从参数中取出-o/--output，status的--json等同于-o json
+func cutOutput(params []string) ([]string, string, error) {
+	out := make([]string, 0, len(params))
+	var mode string
+	for i := 0; i < len(params); i++ {
+		v := params[i]
+		switch {
+		case v == "-o" || v == "--output":
+			if i+1 >= len(params) {
+				return nil, "", errors.New("missing value of " + v)
+			}
+			mode = params[i+1]
+			i++
+		case strings.HasPrefix(v, "-o="):
+			mode = v[3:]
+		case strings.HasPrefix(v, "--output="):
+			mode = v[9:]
+		case v == "--json" && len(out) > 0 && out[0] == model.NameStatus:
+			mode = outputJSON
+		default:
+			out = append(out, v)
+			continue
+		}
+		switch mode {
+		case outputJSON, outputYAML, outputTable, outputWide:
+		default:
+			return nil, "", errors.New("unknown output `" + mode + "`, use json, yaml, table or wide")
+		}
+	}
+	return out, mode, nil
+}
+
+// render 按格式输出结果，失败的结果输出到stderr
+func render(mode string, rs []*model.Response) error {
+	ss := make([]*model.ServiceStatus, 0, len(rs))
+	data := make([]json.RawMessage, 0, len(rs))
+	for _, r := range rs {
+		if !r.OK && len(r.Data) == 0 {
+			println(r.Message)
+			continue
+		}
+		if len(r.Data) == 0 {
+			continue
+		}
+		data = append(data, r.Data)
+		st := &model.ServiceStatus{}
+		if r.Action == model.NameList {
+			x := &model.ServiceInfo{Status: st}
+			json.Unmarshal(r.Data, x)
+		} else {
+			json.Unmarshal(r.Data, st)
+		}
+		ss = append(ss, st)
+	}
+	switch mode {
+	case outputJSON:
+		b, err := json.MarshalIndent(data, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(b))
+	case outputYAML:
+		// 先转为通用结构，字段名和json一致
+		var v any
+		b, _ := json.Marshal(data)
+		if err := json.Unmarshal(b, &v); err != nil {
+			return err
+		}
+		b, err := yaml.Marshal(v)
+		if err != nil {
+			return err
+		}
+		fmt.Print(string(b))
+	case outputTable, outputWide:
+		renderTable(ss, mode == outputWide, rs)
+	}
+	return nil
+}
+
+func renderTable(ss []*model.ServiceStatus, wide bool, rs []*model.Response) {
+	execs := make(map[string]string)
+	if wide {
+		for _, r := range rs {
+			x := &model.ServiceInfo{}
+			if r.Action == model.NameList && json.Unmarshal(r.Data, x) == nil && x.Config != nil {
+				execs[x.Name] = strings.TrimSpace(x.Config.Exec + " " + strings.Join(x.Config.Params, " "))
+			}
+		}
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	head := "NAME\tSTATE\tPID\tUPTIME\tPRIORITY\tENABLED\tRESTARTS"
+	if wide {
+		head += "\tCPU\tRSS\tTHREADS\tFDS\tLAST EXIT"
+		if len(execs) > 0 {
+			head += "\tEXEC"
+		}
+	}
+	fmt.Fprintln(w, head)
+	for _, st := range ss {
+		pid, uptime := "-", "-"
+		if st.Usage != nil {
+			pid = strconv.Itoa(st.Usage.Pid)
+			uptime = formatUptime(st.Usage.Uptime)
+		}
+		enabled := "no"
+		if st.Enable {
+			enabled = "yes"
+		}
+		line := fmt.Sprintf("%s\t%s\t%s\t%s\t%d\t%s\t%d", st.Name, st.State(), pid, uptime, st.Priority, enabled, st.Restarts)
+		if wide {
+			cpu, rss, threads, fds, exit := "-", "-", "-", "-", "-"
+			if st.Usage != nil {
+				cpu = fmt.Sprintf("%.1f%%", st.Usage.CPUPercent)
+				rss = model.FormatBytes(st.Usage.RSS)
+				threads = strconv.Itoa(st.Usage.Threads)
+				fds = strconv.Itoa(st.Usage.FDs)
+			}
+			if st.ExitCode != nil {
+				exit = strconv.Itoa(*st.ExitCode) + " " + time.Unix(st.ExitTime, 0).Format("01-02 15:04:05")
+			}
+			line += "\t" + cpu + "\t" + rss + "\t" + threads + "\t" + fds + "\t" + exit
+			if len(execs) > 0 {
+				line += "\t" + execs[st.Name]
+			}
+		}
+		fmt.Fprintln(w, line)
+	}
+	w.Flush()
+}
+
+// formatUptime 简短的时长，如 3d4h, 5h2m, 4m10s
+func formatUptime(sec int64) string {
+	d, h, m, s := sec/86400, sec%86400/3600, sec%3600/60, sec%60
+	switch {
+	case d > 0:
+		return fmt.Sprintf("%dd%dh", d, h)
+	case h > 0:
+		return fmt.Sprintf("%dh%dm", h, m)
+	case m > 0:
+		return fmt.Sprintf("%dm%ds", m, s)
+	default:
+		return fmt.Sprintf("%ds", s)
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+
+	"extsvr/model"
+)
+
+func TestCutOutput(t *testing.T) {
+	cases := []struct {
+		in     []string
+		out    []string
+		mode   string
+		hasErr bool
+	}{
+		{in: []string{"status", "app"}, out: []string{"status", "app"}},
+		{in: []string{"status", "-o", "json"}, out: []string{"status"}, mode: "json"},
+		{in: []string{"list", "--output", "yaml", "app"}, out: []string{"list", "app"}, mode: "yaml"},
+		{in: []string{"-o=wide", "status"}, out: []string{"status"}, mode: "wide"},
+		{in: []string{"status", "--output=table"}, out: []string{"status"}, mode: "table"},
+		{in: []string{"-o", "json"}, out: []string{}, mode: "json"},
+		{in: []string{"status", "app", "--json"}, out: []string{"status", "app"}, mode: "json"},
+		{in: []string{"audit", "--json"}, out: []string{"audit", "--json"}},
+		{in: []string{"status", "-o"}, hasErr: true},
+		{in: []string{"status", "-o", "xml"}, hasErr: true},
+	}
+	for _, c := range cases {
+		out, mode, err := cutOutput(c.in)
+		if (err != nil) != c.hasErr {
+			t.Errorf("cutOutput(%q) error = %v", c.in, err)
+			continue
+		}
+		if c.hasErr {
+			continue
+		}
+		if !slices.Equal(out, c.out) || mode != c.mode {
+			t.Errorf("cutOutput(%q) = %q, %q, want %q, %q", c.in, out, mode, c.out, c.mode)
+		}
+	}
+}
+
+func TestFormatUptime(t *testing.T) {
+	cases := map[int64]string{
+		0:      "0s",
+		59:     "59s",
+		61:     "1m1s",
+		3600:   "1h0m",
+		3725:   "1h2m",
+		86400:  "1d0h",
+		190800: "2d5h",
+	}
+	for sec, want := range cases {
+		if s := formatUptime(sec); s != want {
+			t.Errorf("formatUptime(%d) = %s, want %s", sec, s, want)
+		}
+	}
+}
+
+// captureStdout 返回f执行期间写到stdout的内容
+func captureStdout(t *testing.T, f func()) string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = old }()
+	done := make(chan string)
+	go func() {
+		b, _ := io.ReadAll(r)
+		done <- string(b)
+	}()
+	f()
+	w.Close()
+	return <-done
+}
+
+func statusResponse(t *testing.T, st *model.ServiceStatus) *model.Response {
+	b, err := json.Marshal(st)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &model.Response{Service: st.Name, Action: model.NameStatus, OK: true, Data: b}
+}
+
+func TestRender(t *testing.T) {
+	rs := []*model.Response{
+		statusResponse(t, &model.ServiceStatus{Name: "app1", Running: true, Enable: true, Priority: 100,
+			Usage: &model.ResourceUsage{Pid: 42, Uptime: 61}}),
+		statusResponse(t, &model.ServiceStatus{Name: "app2", ManualStop: true, Restarts: 3}),
+		{Service: "app3", Action: model.NameStatus, Message: "*** unknow program"},
+	}
+
+	out := captureStdout(t, func() {
+		if err := render(outputJSON, rs); err != nil {
+			t.Fatal(err)
+		}
+	})
+	ss := make([]*model.ServiceStatus, 0)
+	if err := json.Unmarshal([]byte(out), &ss); err != nil {
+		t.Fatalf("json output %q: %v", out, err)
+	}
+	if len(ss) != 2 || ss[0].Name != "app1" || ss[1].Name != "app2" || ss[0].Usage.Pid != 42 {
+		t.Errorf("json output = %s", out)
+	}
+
+	out = captureStdout(t, func() {
+		if err := render(outputYAML, rs); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if !strings.Contains(out, "name: app1") || !strings.Contains(out, "manual_stop: true") {
+		t.Errorf("yaml output = %s", out)
+	}
+
+	out = captureStdout(t, func() {
+		if err := render(outputTable, rs); err != nil {
+			t.Fatal(err)
+		}
+	})
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("table output = %s", out)
+	}
+	want := [][]string{
+		{"NAME", "STATE", "PID", "UPTIME", "PRIORITY", "ENABLED", "RESTARTS"},
+		{"app1", "running", "42", "1m1s", "100", "yes", "0"},
+		{"app2", "stopped", "-", "-", "0", "no", "3"},
+	}
+	for i, l := range lines {
+		if f := strings.Fields(l); !slices.Equal(f, want[i]) {
+			t.Errorf("table line %d = %q, want %q", i, f, want[i])
+		}
+	}
+}
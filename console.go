@@ -0,0 +1,147 @@
+package main
+
+import (
+	"os"
+	"strconv"
+	"sync"
+
+	model "extsvr/model"
+)
+
+// 每个console服务缓存的输出大小
+const consoleBufSize = 64 * 1024
+
+type console struct {
+	locker sync.Mutex
+	master *os.File
+	buf    []byte
+	subs   map[string]peer
+}
+
+var (
+	consoleLocker sync.RWMutex
+	consoles      = make(map[string]*console)
+)
+
+// startConsole 记录服务的pty，并开始读取输出
+func startConsole(name string, master *os.File) {
+	c := &console{
+		master: master,
+		buf:    make([]byte, 0, consoleBufSize),
+		subs:   make(map[string]peer),
+	}
+	consoleLocker.Lock()
+	if old, ok := consoles[name]; ok {
+		old.close(name)
+	}
+	consoles[name] = c
+	consoleLocker.Unlock()
+	go func() {
+		b := make([]byte, 4096)
+		for {
+			n, err := master.Read(b)
+			if n > 0 {
+				c.write(b[:n])
+			}
+			if err != nil { // 子进程退出后读到EIO
+				break
+			}
+		}
+		consoleLocker.Lock()
+		if consoles[name] == c {
+			delete(consoles, name)
+		}
+		consoleLocker.Unlock()
+		c.close(name)
+	}()
+}
+
+func getConsole(name string) (*console, bool) {
+	consoleLocker.RLock()
+	defer consoleLocker.RUnlock()
+	c, ok := consoles[name]
+	return c, ok
+}
+
+func (c *console) write(b []byte) {
+	c.locker.Lock()
+	defer c.locker.Unlock()
+	c.buf = append(c.buf, b...)
+	if len(c.buf) > consoleBufSize {
+		c.buf = append(c.buf[:0], c.buf[len(c.buf)-consoleBufSize:]...)
+	}
+	for k, p := range c.subs {
+		if err := p.Write(b); err != nil {
+			delete(c.subs, k)
+		}
+	}
+}
+
+// attach 先发送缓存的输出，之后的输出实时转发
+func (c *console) attach(p peer, rows, cols uint16) {
+	if rows > 0 && cols > 0 {
+		_ = model.SetWinsize(c.master.Fd(), rows, cols)
+	}
+	c.locker.Lock()
+	defer c.locker.Unlock()
+	if _, ok := c.subs[p.Name()]; ok {
+		return
+	}
+	for i := 0; i < len(c.buf); i += 4096 {
+		p.Write(c.buf[i:min(i+4096, len(c.buf))])
+	}
+	c.subs[p.Name()] = p
+}
+
+// detach 结束attach请求，数据报客户端的attach和detach是同一个地址
+func (c *console) detach(name string) bool {
+	c.locker.Lock()
+	p, ok := c.subs[name]
+	delete(c.subs, name)
+	c.locker.Unlock()
+	if ok {
+		p.End()
+	}
+	return ok
+}
+
+// detachConsoles 客户端断开时从所有console中移除
+func detachConsoles(name string) {
+	consoleLocker.RLock()
+	defer consoleLocker.RUnlock()
+	for _, c := range consoles {
+		c.detach(name)
+	}
+}
+
+func (c *console) input(b []byte) error {
+	_, err := c.master.Write(b)
+	return err
+}
+
+// tail 返回缓存的输出
+func (c *console) tail() []byte {
+	c.locker.Lock()
+	defer c.locker.Unlock()
+	return append([]byte(nil), c.buf...)
+}
+
+func (c *console) close(name string) {
+	c.locker.Lock()
+	defer c.locker.Unlock()
+	for k, p := range c.subs {
+		p.Write([]byte("\r\n[ " + name + " console closed ]\r\n"))
+		p.End()
+		delete(c.subs, k)
+	}
+	c.master.Close()
+}
+
+func parseWinsize(params []string) (uint16, uint16) {
+	if len(params) < 2 {
+		return 0, 0
+	}
+	rows, _ := strconv.ParseUint(params[0], 10, 16)
+	cols, _ := strconv.ParseUint(params[1], 10, 16)
+	return uint16(rows), uint16(cols)
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"crypto/subtle"
+	_ "embed"
+	"net/http"
+	"os"
+	"strings"
+
+	model "extsvr/model"
+)
+
+//go:embed web/index.html
+var dashboardHTML []byte
+
+// startDashboard 启动web管理页面，页面通过/api接口操作，必须设置token或user/password，存在访问策略时不启动
+func startDashboard(ds *model.DashboardSettings) {
+	if ds.Listen == "" {
+		return
+	}
+	if ds.Token == "" && (ds.User == "" || ds.Password == "") {
+		stdlog.Error("dashboard not started, token or user/password must be set")
+		return
+	}
+	if _, err := os.Stat(policyfile); err == nil { // 页面的调用方无法对应到策略中的用户
+		stdlog.Error("dashboard not started, it can not be used when " + policyfile + " exists")
+		return
+	}
+	mux := newAPIMux()
+	mux.HandleFunc("GET /{$}", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write(dashboardHTML)
+	})
+	ln, err := apiListen(ds.Listen)
+	if err != nil {
+		stdlog.Error("dashboard listen error: " + err.Error())
+		return
+	}
+	srv := &http.Server{
+		Handler:     dashboardAuth(ds, apiGuard("", mux)),
+		ConnContext: apiConnContext("dashboard"),
+	}
+	go func() {
+		stdlog.Info("start dashboard: " + ds.Listen)
+		if err := srv.Serve(ln); err != nil {
+			stdlog.Error("dashboard error: " + err.Error())
+		}
+	}()
+}
+
+// dashboardAuth 校验`Authorization: Bearer <token>`或basic auth，
+// 只设置了token时页面本身不校验，由页面提示输入token后访问接口
+func dashboardAuth(ds *model.DashboardSettings, next http.Handler) http.Handler {
+	basic := ds.User != "" && ds.Password != ""
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ds.Token != "" {
+			if t, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok && secureEqual(t, ds.Token) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if !basic && !strings.HasPrefix(r.URL.Path, "/api/") {
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+		if basic {
+			if u, p, ok := r.BasicAuth(); ok && secureEqual(u, ds.User) && secureEqual(p, ds.Password) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			w.Header().Set("WWW-Authenticate", `Basic realm="ssdctld"`)
+		}
+		writeError(w, http.StatusUnauthorized, "unauthorized")
+	})
+}
+
+func secureEqual(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
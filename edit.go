@@ -0,0 +1,85 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	model "extsvr/model"
+
+	"gopkg.in/yaml.v3"
+)
+
+// editSvr ssdctl edit，没有参数时返回配置文件的内容，--dry-run检查修改后的内容并显示和运行中配置的差异，
+// --apply再次检查后写入cnf.d，并按on_config_change重启
+func editSvr(cli *unixClient, todo *model.ToDo) {
+	name := todo.Name
+	file := filepath.Join(cnfdir, name+".yaml")
+	apply := len(todo.Params) > 0 && todo.Params[0] == "--apply"
+	if apply {
+		unlock := lockSvr(name)
+		defer unlock()
+	}
+	old, ok := allconf.GetItem(name)
+	if !ok {
+		cli.Reply(name, model.CodeNotFound, unknowProgram+"`"+name+"`", nil)
+		return
+	}
+	if len(todo.Params) == 0 {
+		b, err := os.ReadFile(file)
+		sum := model.ContentSum(b)
+		if err != nil { // 文件已被删除时使用运行中的配置
+			b, _ = yaml.Marshal(old)
+		}
+		cli.Reply(name, model.CodeOK, "", &model.EditData{Content: string(b), Sum: sum})
+		return
+	}
+	svr, ce := allconf.CheckItem(name, todo.Data)
+	if svr == nil {
+		cli.Reply(name, model.CodeInvalid, "*** "+ce.Error(), ce)
+		return
+	}
+	if ce != nil { // 修改前就有的问题不阻止保存
+		cli.Send(name, formatOutput(name, "WARN", ce.Error()))
+	}
+	ds := model.DiffServices(map[string]*model.ServiceParams{name: old}, map[string]*model.ServiceParams{name: svr})
+	planReload(ds)
+	if !apply {
+		for _, d := range ds {
+			diff := "  " + strings.ReplaceAll(strings.TrimSuffix(d.Unified(), "\n"), "\n", "\n  ")
+			cli.Reply(name, model.CodeOK, formatOutput(name, "DIFF", diff), d)
+		}
+		cli.Reply(name, model.CodeOK, formatOutput("", "EDIT", reloadSummary(ds)), ds)
+		return
+	}
+	// 编辑期间文件被其他人修改时不覆盖
+	cur, _ := os.ReadFile(file)
+	if len(todo.Params) < 2 || model.ContentSum(cur) != todo.Params[1] {
+		cli.Reply(name, model.CodeFailed, formatOutput(name, "EDIT", "cnf.d/"+name+".yaml was changed after the edit began, edit it again"), nil)
+		return
+	}
+	if err := allconf.WriteItem(name, svr, todo.Data); err != nil {
+		cli.Reply(name, model.CodeFailed, formatOutput(name, "EDIT", "save failed: "+err.Error()), nil)
+		return
+	}
+	stdlog.Info("edit " + name)
+	cli.Reply(name, model.CodeOK, formatOutput(name, "EDIT", "saved cnf.d/"+name+".yaml\n"+reloadSummary(ds)), ds)
+	if len(ds) == 0 {
+		return
+	}
+	emit(model.NewEvent(model.EventReloaded, name, 0, "edited"))
+	if ds[0].Action != model.NameRestart {
+		return
+	}
+	s, ok := stopSvrFork(name, ds[0].Old)
+	cli.Reply(name, resultCode(ok), s, nil)
+	if !ok {
+		return
+	}
+	svr, _ = allconf.GetItem(name)
+	s, ok = startSvrFork(name, svr)
+	if !ok { // 启动失败时交给keepalive重试
+		_ = allconf.SetRuntime(name, 0, false)
+	}
+	cli.Reply(name, resultCode(ok), s, nil)
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	model "extsvr/model"
+)
+
+// clean_env时只保留这些变量
+var cleanEnvKeys = []string{"PATH", "HOME", "LANG"}
+
+// buildEnv 生成服务的环境变量:
+// ssdctld的环境变量(clean_env时只保留PATH,HOME,LANG) + env_files + env，后面的覆盖前面的，值里可以引用${NAME}
+func buildEnv(svr *model.ServiceParams) ([]string, error) {
+	env := make([]string, 0)
+	if svr.CleanEnv {
+		for _, k := range cleanEnvKeys {
+			if v, ok := os.LookupEnv(k); ok {
+				env = append(env, k+"="+v)
+			} else if k == "PATH" {
+				env = append(env, "PATH=/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin")
+			}
+		}
+	} else {
+		for _, v := range os.Environ() {
+			if strings.HasPrefix(v, "SSDCTLD_") { // ssdctld自己的设置不传给服务
+				continue
+			}
+			env = append(env, v)
+		}
+	}
+	// 每次启动都重新读取，文件名以'-'开头时文件不存在不报错
+	for _, f := range svr.EnvFiles {
+		optional := strings.HasPrefix(f, "-")
+		f = model.ExpandEnv(strings.TrimPrefix(f, "-"), envLookup(env))
+		if !filepath.IsAbs(f) {
+			f = filepath.Join(cnfdir, f)
+		}
+		fe, err := model.ReadEnvFile(f)
+		if err != nil {
+			if optional && os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+		for _, v := range fe {
+			env = append(env, model.ExpandEnv(v, envLookup(env)))
+		}
+	}
+	for _, v := range svr.Env {
+		env = append(env, model.ExpandEnv(v, envLookup(env)))
+	}
+	return model.DedupEnv(env), nil
+}
+
+// envLookup 在给定的环境变量中查找，后面的覆盖前面的
+func envLookup(env []string) func(string) (string, bool) {
+	return func(key string) (string, bool) {
+		for i := len(env) - 1; i >= 0; i-- {
+			k, v, ok := strings.Cut(env[i], "=")
+			if ok && k == key {
+				return v, true
+			}
+		}
+		return "", false
+	}
+}
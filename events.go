@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+
+	model "extsvr/model"
+)
+
+type subscriber struct {
+	service string
+	ch      chan *model.Event
+	dropped atomic.Uint32 // 处理不过来时丢弃的事件数量
+}
+
+var (
+	subLocker   sync.RWMutex
+	subscribers = make(map[string]*subscriber)
+	sseID       atomic.Uint64
+)
+
+// emit 发送事件给所有订阅者，订阅者处理不过来时丢弃并计数，不属于某个服务的事件(如reloaded)发给所有订阅者
+func emit(ev *model.Event) {
+	subLocker.RLock()
+	defer subLocker.RUnlock()
+	for _, s := range subscribers {
+		if s.service != "" && ev.Service != "" && s.service != ev.Service {
+			continue
+		}
+		select {
+		case s.ch <- ev:
+		default:
+			s.dropped.Add(1)
+		}
+	}
+}
+
+// subscribe 订阅事件，service为空时订阅所有服务
+func subscribe(id, service string) <-chan *model.Event {
+	return subscribeSize(id, service, 64).ch
+}
+
+// subscribeSize 指定队列长度订阅事件
+func subscribeSize(id, service string, size int) *subscriber {
+	s := &subscriber{
+		service: service,
+		ch:      make(chan *model.Event, size),
+	}
+	subLocker.Lock()
+	if old, ok := subscribers[id]; ok {
+		close(old.ch)
+	}
+	subscribers[id] = s
+	subLocker.Unlock()
+	return s
+}
+
+func unsubscribe(id string) {
+	subLocker.Lock()
+	if s, ok := subscribers[id]; ok {
+		close(s.ch)
+		delete(subscribers, id)
+	}
+	subLocker.Unlock()
+}
+
+// subscribePeer 将事件以json发送给客户端，直到客户端退出或取消订阅
+func subscribePeer(p peer, service string) {
+	ch := subscribe(p.Name(), service)
+	go func() {
+		for ev := range ch {
+			b, _ := json.Marshal(ev)
+			if err := p.Write(b); err != nil {
+				unsubscribe(p.Name())
+			}
+		}
+	}()
+}
+
+// apiEvents 以server-sent events输出事件
+func apiEvents(w http.ResponseWriter, r *http.Request) {
+	if !apiAllow(w, r, model.JobEvents, r.URL.Query().Get("service")) {
+		return
+	}
+	fl, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, "streaming not supported")
+		return
+	}
+	id := "http:" + strconv.FormatUint(sseID.Add(1), 10)
+	ch := subscribe(id, r.URL.Query().Get("service"))
+	defer unsubscribe(id)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	fl.Flush()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case ev, ok := <-ch:
+			if !ok {
+				return
+			}
+			b, _ := json.Marshal(ev)
+			w.Write([]byte("data: " + string(b) + "\n\n"))
+			fl.Flush()
+		}
+	}
+}
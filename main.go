@@ -8,6 +8,8 @@ import (
 	"os/exec"
 	"os/user"
 	"path/filepath"
+	"slices"
+	"sort"
 	"strconv"
 	"strings"
 	"syscall"
@@ -59,10 +61,14 @@ var (
 	exename    = gocmd.GetExecName()
 	confile    = gocmd.JoinPathFromHere("ssdctld.yaml")
 	confileOld = gocmd.JoinPathFromHere("extsvr.yaml")
+	setfile    = gocmd.JoinPathFromHere("ssdctld.settings.yaml")
+	statefile  = gocmd.JoinPathFromHere("ssdctld.state")
+	policyfile = gocmd.JoinPathFromHere("ssdctld.policy.yaml")
 	logdir     = gocmd.JoinPathFromHere("log")
 	piddir     = gocmd.JoinPathFromHere("pid.d")
 	cnfdir     = gocmd.JoinPathFromHere("cnf.d")
 	allconf    *model.Config
+	settings   *model.Settings
 	uln        *net.UnixConn
 
 	app     *gocmd.Program
@@ -73,8 +79,15 @@ var (
 )
 
 type unixClient struct {
-	conn *net.UnixAddr
+	peer
 	buf  []byte
+	cred *syscall.Ucred
+	out  strings.Builder // 发送的内容，用于审计
+	keep bool            // attach和events在之后才结束
+	// 退出和重新执行前已经记录审计
+	audited bool
+	// 当前请求的命令名
+	action string
 }
 
 func (uc *unixClient) Send(name, s string) {
@@ -87,14 +100,37 @@ func (uc *unixClient) Send(name, s string) {
 			continue
 		}
 		switch v[0] {
-		case '[', '<', '>', '+', '-', '*':
+		case '[', '<', '>', '+', '-', '*', '{':
 			b.WriteString(v)
 		default:
 			b.WriteString("  " + v)
 		}
 		b.WriteByte(10)
 	}
-	uln.WriteToUnix(json.Bytes(b.String()), uc.conn)
+	if uc.out.Len() < 4096 {
+		uc.out.WriteString(s + "\n")
+	}
+	uc.Write(json.Bytes(b.String()))
+}
+
+// Reply 发送给人看的输出，流协议的客户端还会收到结构化的结果
+func (uc *unixClient) Reply(service string, code int, text string, data any) {
+	uc.Send(service, text)
+	sp, ok := uc.peer.(*streamPeer)
+	if !ok {
+		return
+	}
+	r := &model.Response{
+		Service: service,
+		Action:  uc.action,
+		OK:      code == model.CodeOK,
+		Code:    code,
+		Message: strings.TrimSpace(plainOutput(text)),
+	}
+	if data != nil {
+		r.Data, _ = json.Marshal(data)
+	}
+	sp.reply(r)
 }
 
 func main() {
@@ -122,12 +158,87 @@ app1:                    // program name
     - -c=$pubip          // '$public' will be replaced by the replace setting before run
   env:                   // set the sys env, should be 'key=value' format
     - https_proxy=http:127.0.0.1:8080
+  env_files:             // dotenv files, reload on every start, relative to cnf.d, prefix '-' to ignore missing file
+    - -app1.env
+  clean_env: true        // only keep PATH, HOME, LANG from ssdctld's env
   replace:               // params replacer, can replace params variable before run, should be 'key=value' format, and key must start with '$'
     - $pubip=curl -s 4.ipw.cn
+    - key: $region       // or the full format, with timeout, default value and cache ttl in seconds
+      cmd: sh -c "cat /etc/region || echo cn"
+      timeout: 5
+      default: cn
+      cache: 600
   log2file: true         // save program stdout to ./log/[program name].log
+  console: true          // run program in a pseudo-terminal, use 'ssdctl attach app1' to interact with it
+  tags: [backend]        // used by services in ssdctld.policy.yaml
+  notify:                // notifiers in ssdctld.settings.yaml to use when the program crashes, restarts, fails to restart, becomes FATAL or recovers
+    - ops
+  on_config_change: restart // restart the running program when its config file changes, default none
   enable: true           // enable autostart and timer check
 
-in this case, $pubip will be replace to the result of 'curl -s 4.ipw.cn'`,
+in this case, $pubip will be replace to the result of 'curl -s 4.ipw.cn',
+if the command failed and no default value is set, the program will not start.
+${NAME} in params, dir and env will be replaced by the env value.
+
+cnf.d/_defaults.yaml is merged under every program, files starting with '_' are templates
+and not loaded as programs:
+cnf.d/_java.yaml:
+  exec: /usr/bin/java
+  env:
+    - JAVA_HOME=/opt/jdk
+cnf.d/app2.yaml:
+  extends: _java         // inherit another program or template, maps are merged, other values and lists are replaced
+  params:
+    - -jar
+    - app2.jar
+  env+:                  // 'key+' appends to the inherited list
+    - TZ=UTC
+use 'ssdctl list app2 --effective' to show the merged config.
+
+ssdctld.settings.yaml.sample:
+metrics: 127.0.0.1:9120  // prometheus metrics listen address, serve on /metrics, empty to disable
+api: unix:/run/ssdctld/api.sock // http/json api listen address, unix socket or localhost tcp, empty to disable
+api_token: change-me    // required when api listen on tcp, use 'Authorization: Bearer change-me'
+  // requests other than GET must use 'Content-Type: application/json', requests from other origins are refused
+  // when the policy file exists, only unix socket is allowed and callers are checked by their uid/gid as ssdctl
+  // GET    /api/services[?tree]          list configs and status
+  // POST   /api/services                 create, {"name":"app1","exec":"/op/aa","params":[]}
+  // GET    /api/services/{name}[?tree]   get config and status
+  // PUT    /api/services/{name}          replace config
+  // DELETE /api/services/{name}          remove config
+  // POST   /api/services/{name}/{action} start, stop, restart, enable or disable, name can be 'all' for start and stop
+  // GET    /api/status[?tree]            status of all programs
+  // GET    /api/services/{name}/logs     recent log lines
+  // POST   /api/reload                   reload all config in cnf.d, 422 with {"errors":[{file, errors, skipped}]} if any file has errors
+dashboard:               // web dashboard, token or user/password must be set, not started when the policy file exists
+  listen: 127.0.0.1:9122 // unix socket or localhost only, use a tls reverse proxy for remote access
+  token: change-me       // use 'Authorization: Bearer change-me'
+  user: admin            // or basic auth
+  password: change-me
+fatal_after: 5           // stop restarting a program after 5 consecutive failed restarts (FATAL) until 'ssdctl start', 0 to retry forever
+notifiers:
+  - name: ops
+    webhook: https://hooks.example.com/ssdctld // POST {"kind":"crash","host":"...","event":{...},"suppressed":0}
+    headers:
+      X-Token: change-me
+    events: [crash, restart, restart_failed, fatal, recovery] // default all
+    rate: 10             // max notifications per minute, default 10
+    retry: 3             // retries with backoff on failure, default 3
+  - name: local
+    command: /op/notify.sh // the same json is written to stdin
+reload:                  // what to do when cnf.d is reloaded by 'ssdctl update' or file changes
+  watch: true            // watch cnf.d with inotify and reload on changes
+  debounce: 2            // seconds to wait after the last change, default 2
+  start_new: true        // start new enabled programs, otherwise they stay stopped until 'ssdctl start'
+  stop_removed: true     // stop programs whose config file was removed
+
+ssdctld.policy.yaml.sample: // access control of ssdctl, no limit if the file not exist, root is always allowed
+rules:
+  - groups: [ops]          // group names or gids, users: [] for user names or uids
+    allow: [read, control] // read, control, console, config, admin
+  - users: [alice]
+    allow: [control, console]
+    services: [web, tag:backend] // program names or tags, empty for all programs`,
 	}).
 		AddCommand(&gocmd.Command{
 			Name:     "systemd",
@@ -188,9 +299,48 @@ in this case, $pubip will be replace to the result of 'curl -s 4.ipw.cn'`,
 	if gocmd.IsExist(confileOld) {
 		os.Rename(confileOld, confile)
 	}
+	// 重新执行时恢复console和子进程归属
+	loadHandoff()
 	allconf = model.NewCnf(cnfdir, piddir)
 	allconf.ConverFromOld()
-	allconf.FromFiles()
+	logConfigErrors(allconf.FromFiles())
+	// 恢复上次运行时的手动停止、重启次数、退出码和FATAL状态，需要在keepalive之前
+	if err := allconf.UseState(statefile); err != nil {
+		stdlog.Error("load state error: " + err.Error())
+	}
+	var err error
+	settings, err = model.LoadSettings(setfile)
+	if err != nil {
+		stdlog.Error("load settings error: " + err.Error())
+	}
+	if settings.Metrics != "" {
+		startMetrics(settings.Metrics)
+	}
+	if settings.API != "" {
+		startAPI(settings.API, settings.APIToken)
+	}
+	if settings.Dashboard != nil {
+		startDashboard(settings.Dashboard)
+	}
+	if len(settings.Notifiers) > 0 {
+		startNotifiers(settings.Notifiers)
+	}
+	if settings.Reload != nil && settings.Reload.Watch {
+		if settings.Reload.Debounce == 0 {
+			settings.Reload.Debounce = 2
+		}
+		if err := watchConfig(time.Second * time.Duration(settings.Reload.Debounce)); err != nil {
+			stdlog.Error("watch " + cnfdir + " error: " + err.Error())
+		}
+	}
+	loadPolicy()
+	if err := openAudit(); err != nil {
+		stdlog.Error("open audit log error: " + err.Error())
+	}
+	if err := setSubreaper(); err != nil {
+		stdlog.Error("set child subreaper error: " + err.Error())
+	}
+	go reapLoop()
 
 	// 后台处理
 	td := time.Second * time.Duration(min(max(toolbox.String2Int(os.Getenv("SSDCTLD_CHECK_SECONDS"), 10), 60), 600))
@@ -200,47 +350,82 @@ in this case, $pubip will be replace to the result of 'curl -s 4.ipw.cn'`,
 		go loopfunc.LoopFunc(func(params ...any) {
 			t.Reset(td)
 			for range t.C {
+				scanStart := time.Now()
 				procCache := map[string][]*model.ProcessInfo{}
+				procs := model.ScanProcs()
 				// 检查所有enable==true && manualStop==false的服务状态
 				allconf.ForEach(func(key string, value *model.ServiceParams) bool {
-					if !value.Enable || value.ManualStop {
+					if value.Pid > 0 { // 记录子进程归属，便于之后收割孤儿进程
+						svrChildren(key, value.Pid, procs)
+					}
+					if !value.Enable || value.ManualStop || value.Fatal {
+						return true
+					}
+					// 正在启动、停止的服务跳过，锁定后重新读取状态
+					unlock, ok := tryLockSvr(key)
+					if !ok {
+						return true
+					}
+					defer unlock()
+					if value, ok = allconf.GetItem(key); !ok || !value.Enable || value.ManualStop || value.Fatal {
 						return true
 					}
 					if _, _, ok := svrIsRunningCached(value, procCache); ok {
 						return true
 					}
-					s, _ := startSvrFork(key, value)
+					svrCrashed(key, 0, "not running")
+					s, ok := startSvrFork(key, value)
 					delete(procCache, filepath.Base(value.Exec))
+					_ = allconf.AddRestart(key)
+					if ok {
+						emit(model.NewEvent(model.EventRestarted, key, 0, "restarted by keepalive"))
+					} else {
+						emit(model.NewEvent(model.EventRestartFailed, key, 0, "restart by keepalive failed"))
+						svrRestartFailed(key)
+					}
 					stdlog.Info(key + " not running, restart... " + s)
 					return true
 				})
+				countScan(time.Since(scanStart))
 				t.Reset(td)
 			}
 		}, "recv", nil) // stdlog.DefaultWriter())
 	}
-	// 开始监听
+	// 请求并发处理，同一服务的操作由lockSvr串行，keepalive不受影响
+	if err := listenStream(recv); err != nil {
+		stdlog.Error("listen from unix stream error: " + err.Error())
+		app.Exit(1)
+	}
+	handleSignals()
+	// 开始监听，数据报socket只为兼容旧版本客户端
 	loopfunc.LoopFunc(func(params ...any) {
 		var err error
-		uln, err = net.ListenUnixgram("unixgram", model.SvrAddr)
+		uln, err = inheritedDgram()
+		if uln == nil && err == nil {
+			uln, err = net.ListenUnixgram("unixgram", model.SvrAddr)
+		}
 		if err != nil {
 			stdlog.Error("listen from unixgram error: " + err.Error())
 			app.Exit(1)
 		}
+		if err := setPassCred(uln); err != nil {
+			stdlog.Error("set SO_PASSCRED error: " + err.Error())
+		}
 		stdlog.Info("start receiving from unix socket:" + model.SvrSock)
 		buf := make([]byte, 2048)
+		oob := make([]byte, syscall.CmsgSpace(syscall.SizeofUcred))
 		// 监听客户端
 		for {
-			n, cli, err := uln.ReadFromUnix(buf)
+			n, oobn, _, cli, err := uln.ReadMsgUnix(buf, oob)
 			if err != nil {
 				stdlog.Error("read from unix socket error: " + err.Error())
 				continue
 			}
-			t.Stop()
-			recv(&unixClient{
-				conn: cli,
-				buf:  buf[:n],
-			})
-			t.Reset(td)
+			dispatchDgram(&unixClient{
+				peer: &dgramPeer{addr: cli},
+				buf:  slices.Clone(buf[:n]),
+				cred: parseCred(oob[:oobn]),
+			}, recv)
 		}
 	}, "main proc", nil) // stdlog.DefaultWriter())
 }
@@ -257,18 +442,50 @@ func recv(cli *unixClient) {
 	todo := &model.ToDo{}
 	err := todo.FromJSON(cli.buf)
 	if err != nil {
-		cli.Send("error", err.Error())
-		uln.WriteToUnix(json.Bytes("END"), cli.conn)
+		cli.Reply("", model.CodeInvalid, err.Error(), nil)
+		cli.End()
+		return
+	}
+	cli.action = todo.Do.String()
+	countRequest(todo.Do)
+	if todo.Do != model.JobEnd && todo.Do != model.JobInput { // console输入可能包含密码，不记录
+		defer audit(cli, todo)
+	}
+	if err := authorize(cli.cred, todo); err != nil {
+		if todo.Do == model.JobInput {
+			return
+		}
+		cli.Reply(todo.Name, model.CodeDenied, "*** "+err.Error(), nil)
+		if todo.Do == model.JobAttach || todo.Do == model.JobEvents {
+			cli.End()
+		}
 		return
 	}
 	exe, ok := allconf.GetItem(todo.Name)
 	switch todo.Do {
 	case model.JobEnd: // 关闭
-		uln.WriteToUnix(json.Bytes("END"), cli.conn)
+		unsubscribe(cli.Name())
+		cli.End()
+		return
+	case model.JobAudit: // 查询审计日志
+		since, _ := strconv.ParseInt(todo.Exec, 10, 64)
+		for _, r := range auditQuery(since) {
+			if todo.Format == model.FormatJSON {
+				b, _ := json.Marshal(r)
+				cli.Reply("", model.CodeOK, string(b), r)
+			} else {
+				cli.Reply("", model.CodeOK, r.String(), r)
+			}
+		}
+		return
+	case model.JobEvents: // 订阅事件
+		subscribePeer(cli.peer, todo.Name)
+		cli.keep = true
+		stdlog.Info("subscribe events " + todo.Name)
 		return
 	case model.JobStart: // 启动
 		if !ok && todo.Name != model.NameAll {
-			cli.Send(todo.Name, unknowProgram+"`"+todo.Name+"`")
+			cli.Reply(todo.Name, model.CodeNotFound, unknowProgram+"`"+todo.Name+"`", nil)
 			return
 		}
 		if todo.Name == model.NameAll {
@@ -278,19 +495,19 @@ func recv(cli *unixClient) {
 					return true
 				}
 				cli.Send(todo.Name, formatOutput(todo.Name, "STARTING...", "||> "+value.Exec+" "+strings.Join(value.Params, " "))) //"[STARTING...] "+todo.Name)
-				s, _ := startSvrFork(key, value)
-				cli.Send(key, s)
+				s, ok := startSvr(key, value)
+				cli.Reply(key, resultCode(ok), s, nil)
 				return true
 			})
 		} else {
 			cli.Send(todo.Name, formatOutput(todo.Name, "STARTING...", "||> "+exe.Exec+" "+strings.Join(exe.Params, " "))) //"[STARTING...] "+todo.Name)
-			s, _ := startSvrFork(todo.Name, exe)
-			cli.Send(todo.Name, s)
+			s, ok := startSvr(todo.Name, exe)
+			cli.Reply(todo.Name, resultCode(ok), s, nil)
 			stdlog.Info(s)
 		}
 	case model.JobStop: // 停止
 		if !ok && todo.Name != model.NameAll {
-			cli.Send(todo.Name, unknowProgram+"`"+todo.Name+"`")
+			cli.Reply(todo.Name, model.CodeNotFound, unknowProgram+"`"+todo.Name+"`", nil)
 			return
 		}
 		if todo.Name == model.NameAll {
@@ -299,48 +516,52 @@ func recv(cli *unixClient) {
 				if !value.Enable {
 					return true
 				}
-				if strings.Contains(value.Exec, "ttyd") ||
-					strings.Contains(value.Exec, "caddy") ||
-					strings.Contains(value.Exec, "dragonfly") ||
-					strings.Contains(value.Exec, "stmq") {
+				if keepOnStopAll(value) {
 					return true
 				}
-				cli.Send(key, stopSvrFork(key, value))
+				s, ok := stopSvr(key, value)
+				cli.Reply(key, resultCode(ok), s, nil)
 				return true
 			})
 		} else {
-			s := stopSvrFork(todo.Name, exe)
-			cli.Send(todo.Name, s)
+			s, ok := stopSvr(todo.Name, exe)
+			cli.Reply(todo.Name, resultCode(ok), s, nil)
 			stdlog.Warning(s)
 		}
 	case model.JobEnable: // 启用
 		if !ok {
-			cli.Send(todo.Name, unknowProgram+"`"+todo.Name+"`")
+			cli.Reply(todo.Name, model.CodeNotFound, unknowProgram+"`"+todo.Name+"`", nil)
 			return
 		}
 		allconf.SetEnable(todo.Name, true)
-		cli.Send(todo.Name, ">>> "+todo.Name+" enabled")
+		emit(model.NewEvent(model.EventEnabled, todo.Name, 0, ""))
+		cli.Reply(todo.Name, model.CodeOK, ">>> "+todo.Name+" enabled", nil)
 		stdlog.Info("enable " + todo.Name)
 	case model.JobDisable: // 停用
 		if !ok {
-			cli.Send(todo.Name, unknowProgram+"`"+todo.Name+"`")
+			cli.Reply(todo.Name, model.CodeNotFound, unknowProgram+"`"+todo.Name+"`", nil)
 			return
 		}
 		allconf.SetEnable(todo.Name, false)
-		cli.Send(todo.Name, ">>> "+todo.Name+" disabled")
+		emit(model.NewEvent(model.EventDisabled, todo.Name, 0, ""))
+		cli.Reply(todo.Name, model.CodeOK, ">>> "+todo.Name+" disabled", nil)
 		stdlog.Info("disable " + todo.Name)
 	case model.JobRemove: // 删除服务
+		if !ok {
+			cli.Reply(todo.Name, model.CodeNotFound, "--- "+todo.Name+" remove failed: service "+todo.Name+" not exist", nil)
+			return
+		}
+		unlock := lockSvr(todo.Name)
+		defer unlock()
 		if err := allconf.DelItem(todo.Name); err != nil {
-			cli.Send(todo.Name, "--- "+todo.Name+" remove failed: "+err.Error())
+			cli.Reply(todo.Name, model.CodeFailed, "--- "+todo.Name+" remove failed: "+err.Error(), nil)
 		} else {
-			cli.Send(todo.Name, "--- "+todo.Name+" removed")
+			cli.Reply(todo.Name, model.CodeOK, "--- "+todo.Name+" removed", nil)
 			stdlog.Info("remove " + todo.Name)
 		}
 	case model.JobCreate: // 新增服务
-		switch todo.Name {
-		case model.NameAll, model.NameDisable, model.NameEnable, model.NameStatus, model.NameStart, model.NameStop,
-			model.NameStopped, model.NameRestart, model.NameRemove, model.NameCreate, model.NameList, model.NameRunning:
-			cli.Send("all", "can not use `"+todo.Name+"` as application's name")
+		if err := model.CheckName(todo.Name); err != nil {
+			cli.Reply(todo.Name, model.CodeInvalid, err.Error(), nil)
 			return
 		}
 		if err := allconf.AddItem(todo.Name, &model.ServiceParams{
@@ -348,127 +569,354 @@ func recv(cli *unixClient) {
 			Params: todo.Params,
 			Enable: true,
 		}); err != nil {
-			cli.Send(todo.Name, "+++ "+todo.Name+" add failed: "+err.Error())
+			cli.Reply(todo.Name, model.CodeFailed, "+++ "+todo.Name+" add failed: "+err.Error(), nil)
 		} else {
-			cli.Send(todo.Name, "+++ "+todo.Name+" added")
+			cli.Reply(todo.Name, model.CodeOK, "+++ "+todo.Name+" added", nil)
 			stdlog.Info("add " + todo.Name)
 		}
 	case model.JobStatus: // 状态查询
 		switch todo.Name {
 		case model.NameRunning:
 			allconf.ForEach(func(key string, value *model.ServiceParams) bool {
-				_, s, ok := svrIsRunning(value)
-				if ok {
-					cli.Send(key, formatOutput(key, "PS", s)) //"[PS\t"+key+"]:\n"+s)
+				if _, _, ok := svrIsRunning(value); ok {
+					replyStatus(cli, key, value, todo)
 				}
 				return true
 			})
 		case model.NameDisable:
 			allconf.ForEach(func(key string, value *model.ServiceParams) bool {
-				if value.Enable {
-					return true
-				}
-				_, s, ok := svrIsRunning(value)
-				if ok {
-					cli.Send(key, formatOutput(key, "PS", s)) //"[PS\t"+key+"]:\n"+s)
-				} else {
-					cli.Send(key, formatOutput(key, "PS", "not running"))
+				if !value.Enable {
+					replyStatus(cli, key, value, todo)
 				}
 				return true
 			})
 		case model.NameEnable:
 			allconf.ForEach(func(key string, value *model.ServiceParams) bool {
-				if !value.Enable {
-					return true
-				}
-				_, s, ok := svrIsRunning(value)
-				if ok {
-					cli.Send(key, formatOutput(key, "PS", s)) //"[PS\t"+key+"]:\n"+s)
-				} else {
-					cli.Send(key, formatOutput(key, "PS", "not running"))
+				if value.Enable {
+					replyStatus(cli, key, value, todo)
 				}
 				return true
 			})
 		case model.NameAll:
 			allconf.ForEach(func(key string, value *model.ServiceParams) bool {
 				if value.Enable {
-					cli.Send(key, statusSvr(key, value))
+					replyStatus(cli, key, value, todo)
 				}
 				return true
 			})
 		default:
 			if !ok {
-				cli.Send(todo.Name, unknowProgram+"`"+todo.Name+"`")
+				cli.Reply(todo.Name, model.CodeNotFound, unknowProgram+"`"+todo.Name+"`", nil)
 				return
 			}
-			cli.Send(todo.Name, statusSvr(todo.Name, exe))
+			replyStatus(cli, todo.Name, exe, todo)
+		}
+	case model.JobPs: // 进程树
+		if !ok {
+			cli.Reply(todo.Name, model.CodeNotFound, unknowProgram+"`"+todo.Name+"`", nil)
+			return
 		}
+		cli.Reply(todo.Name, model.CodeOK, psSvr(todo.Name, exe), nil)
 	case model.JobList:
+		effective := slices.Contains(todo.Params, "--effective")
 		switch todo.Name {
 		case model.NameEnable:
 			allconf.ForEach(func(key string, value *model.ServiceParams) bool {
 				if value.Enable {
-					cli.Send(key, listSvr(key, value))
+					cli.Reply(key, model.CodeOK, listSvr(key, value, effective), svrInfo(key, value))
 				}
 				return true
 			})
 		case model.NameDisable:
 			allconf.ForEach(func(key string, value *model.ServiceParams) bool {
 				if !value.Enable {
-					cli.Send(key, listSvr(key, value))
+					cli.Reply(key, model.CodeOK, listSvr(key, value, effective), svrInfo(key, value))
 				}
 				return true
 			})
 		case model.NameStopped:
 			allconf.ForEach(func(key string, value *model.ServiceParams) bool {
 				if value.Enable && value.ManualStop {
-					cli.Send(key, listSvr(key, value))
+					cli.Reply(key, model.CodeOK, listSvr(key, value, effective), svrInfo(key, value))
 				}
 				return true
 			})
 		case "":
-			cli.Send("", allconf.Print())
+			cli.Reply("", model.CodeOK, allconf.Print(), nil)
 		case model.NameAll:
 			allconf.ForEach(func(key string, value *model.ServiceParams) bool {
-				cli.Send(key, listSvr(key, value))
+				cli.Reply(key, model.CodeOK, listSvr(key, value, effective), svrInfo(key, value))
 				return true
 			})
 		default:
 			if !ok {
-				cli.Send(todo.Name, unknowProgram+"`"+todo.Name+"`")
+				cli.Reply(todo.Name, model.CodeNotFound, unknowProgram+"`"+todo.Name+"`", nil)
 				return
 			}
-			cli.Send(todo.Name, listSvr(todo.Name, exe))
+			cli.Reply(todo.Name, model.CodeOK, listSvr(todo.Name, exe, effective), svrInfo(todo.Name, exe))
 		}
 	case model.JobUpate: // 列出所有，刷新
-		allconf.FromFiles()
-		cli.Send("", allconf.Print())
+		if slices.Contains(todo.Params, "--dry-run") {
+			ds, errs := reloadConfig(false, nil)
+			for _, e := range errs {
+				cli.Reply(strings.TrimSuffix(e.File, ".yaml"), model.CodeInvalid, "*** "+e.Error(), e)
+			}
+			for _, d := range ds {
+				// 缩进每一行，避免Send只缩进部分行导致diff错位
+				diff := "  " + strings.ReplaceAll(strings.TrimSuffix(d.Unified(), "\n"), "\n", "\n  ")
+				cli.Reply(d.Name, model.CodeOK, formatOutput(d.Name, "DIFF", diff), d)
+			}
+			cli.Reply("", model.CodeOK, formatOutput("", "DRY RUN", reloadSummary(ds)), ds)
+			return
+		}
+		type result struct {
+			name, s string
+			ok      bool
+		}
+		rs := make([]*result, 0)
+		unlock := lockAll()
+		ds, errs := reloadConfig(true, func(name, s string, ok bool) {
+			rs = append(rs, &result{name: name, s: s, ok: ok})
+		})
+		unlock()
+		loadPolicy()
+		logConfigErrors(errs)
+		emit(model.NewEvent(model.EventReloaded, "", 0, strconv.Itoa(allconf.Len())+" services"))
+		for _, e := range errs {
+			cli.Reply(strings.TrimSuffix(e.File, ".yaml"), model.CodeInvalid, "*** "+e.Error(), e)
+		}
+		cli.Reply("", model.CodeOK, allconf.Print(), nil)
+		cli.Reply("", model.CodeOK, formatOutput("", "RELOAD", reloadSummary(ds)), ds)
+		for _, r := range rs {
+			cli.Reply(r.name, resultCode(r.ok), r.s, nil)
+		}
+	case model.JobAttach: // 连接console
+		if !ok {
+			cli.Reply(todo.Name, model.CodeNotFound, unknowProgram+"`"+todo.Name+"`", nil)
+			cli.End()
+			return
+		}
+		c, found := getConsole(todo.Name)
+		if !found {
+			cli.Reply(todo.Name, model.CodeFailed, formatOutput(todo.Name, "ATTACH", "no console, set `console: true` and restart the program"), nil)
+			cli.End()
+			return
+		}
+		rows, cols := parseWinsize(todo.Params)
+		c.attach(cli.peer, rows, cols)
+		cli.keep = true
+		stdlog.Info("attach " + todo.Name)
+	case model.JobInput: // console输入
+		if c, found := getConsole(todo.Name); found {
+			if len(todo.Data) > 0 {
+				c.input(todo.Data)
+			}
+			if rows, cols := parseWinsize(todo.Params); rows > 0 && cols > 0 {
+				_ = model.SetWinsize(c.master.Fd(), rows, cols)
+			}
+		}
+	case model.JobDetach: // 断开console
+		if c, found := getConsole(todo.Name); !found || !c.detach(cli.Name()) {
+			cli.End()
+		}
 	case model.JobSetLevel: // 设置优先级
 		if !ok {
-			cli.Send(todo.Name, unknowProgram+"`"+todo.Name+"`")
+			cli.Reply(todo.Name, model.CodeNotFound, unknowProgram+"`"+todo.Name+"`", nil)
 			return
 		}
 		allconf.SetLevel(todo.Name, uint32(toolbox.String2Int32(todo.Exec, 10)))
-		cli.Send(todo.Name, ">>> set "+todo.Name+" start level to "+strconv.FormatUint(uint64(toolbox.String2Int32(todo.Exec, 10)), 10))
+		cli.Reply(todo.Name, model.CodeOK, ">>> set "+todo.Name+" start level to "+strconv.FormatUint(uint64(toolbox.String2Int32(todo.Exec, 10)), 10), nil)
+	case model.JobEdit: // 编辑服务配置
+		editSvr(cli, todo)
+	case model.JobReexec: // 重新执行ssdctld
+		if err := reexec(cli, todo); err != nil {
+			cli.Reply("", model.CodeFailed, "*** reexec failed: "+err.Error(), nil)
+		}
+	case model.JobShutdown: // 退出ssdctld
+		if !shutdown(slices.Contains(todo.Params, "--stop-services"), cli) {
+			cli.Reply("", model.CodeFailed, "*** ssdctld is already shutting down", nil)
+			return
+		}
+		cli.Reply("", model.CodeOK, formatOutput("", "SHUTDOWN", "ssdctld exited"), nil)
+		cli.End()
+		audit(cli, todo) // 退出时不会执行defer
+		app.Exit(0)
+	default:
+		cli.Reply(todo.Name, model.CodeInvalid, "unsupported command `"+todo.Do.String()+"`", nil)
 	}
 }
 
-func statusSvr(name string, svr *model.ServiceParams) string {
-	_, ps, ok := svrIsRunning(svr)
+// logConfigErrors 记录加载配置时每个文件的问题
+func logConfigErrors(errs []*model.ConfigError) {
+	for _, e := range errs {
+		stdlog.Error("config " + e.Error())
+	}
+}
+
+// keepOnStopAll stop all时不停止的基础服务
+func keepOnStopAll(svr *model.ServiceParams) bool {
+	return strings.Contains(svr.Exec, "ttyd") ||
+		strings.Contains(svr.Exec, "caddy") ||
+		strings.Contains(svr.Exec, "dragonfly") ||
+		strings.Contains(svr.Exec, "stmq")
+}
+
+// resultCode 启停等操作的结果码
+func resultCode(ok bool) int {
+	if ok {
+		return model.CodeOK
+	}
+	return model.CodeFailed
+}
+
+// replyStatus 按LSB的习惯，运行中为0，否则为3
+func replyStatus(cli *unixClient, name string, svr *model.ServiceParams, todo *model.ToDo) {
+	s, st := statusSvr(name, svr, todo.Tree)
+	code := model.CodeOK
+	if !st.Running {
+		code = model.StatusStopped
+	}
+	cli.Reply(name, code, s, st)
+}
+
+func statusSvr(name string, svr *model.ServiceParams, tree bool) (string, *model.ServiceStatus) {
+	pid, ps, ok := svrIsRunning(svr)
+	var ru *model.ResourceUsage
+	var pt *model.ProcNode
+	var children []*model.ProcStat
+	if ok { // 只遍历一次/proc
+		procs := model.ScanProcs()
+		children = svrChildren(name, pid, procs)
+		ru = svrUsage(name, pid, children, procs)
+		if tree {
+			pt = svrTree(pid, children, procs)
+		}
+	}
+	st := newStatus(name, svr, ok, ru, pt)
 	if !ok {
-		return formatOutput(name, "PS", "not running") // "[PS\t" + name + "]:\nnot running"
-	} else {
-		return formatOutput(name, "PS", ps) //"[PS\t" + name + "]:\n" + ps
+		if svr.Fatal {
+			return formatOutput(name, "PS", "not running, FATAL after "+strconv.Itoa(int(svr.Fails))+" failed restarts, use `start` to retry"), st
+		}
+		return formatOutput(name, "PS", "not running"), st // "[PS\t" + name + "]:\nnot running"
+	}
+	if pt != nil {
+		ps = pt.Render()
+	}
+	if ru != nil {
+		ps += "\n" + ru.String()
+	}
+	if pt == nil {
+		ps += childrenOutput(children)
+	}
+	return formatOutput(name, "PS", ps), st //"[PS\t" + name + "]:\n" + ps
+}
+
+// svrStatus 服务的结构化状态
+func svrStatus(name string, svr *model.ServiceParams, tree bool) *model.ServiceStatus {
+	pid, _, ok := svrIsRunning(svr)
+	if !ok {
+		return newStatus(name, svr, false, nil, nil)
+	}
+	procs := model.ScanProcs()
+	children := svrChildren(name, pid, procs)
+	var pt *model.ProcNode
+	if tree {
+		pt = svrTree(pid, children, procs)
 	}
+	return newStatus(name, svr, true, svrUsage(name, pid, children, procs), pt)
 }
 
-func listSvr(name string, svr *model.ServiceParams) string {
+// svrInfo 服务的配置和状态
+func svrInfo(name string, svr *model.ServiceParams) *model.ServiceInfo {
+	return &model.ServiceInfo{
+		Name:   name,
+		Config: svr.Redacted(),
+		Status: svrStatus(name, svr, false),
+	}
+}
+
+func newStatus(name string, svr *model.ServiceParams, running bool, ru *model.ResourceUsage, pt *model.ProcNode) *model.ServiceStatus {
+	st := &model.ServiceStatus{
+		Name:       name,
+		Running:    running,
+		Enable:     svr.Enable,
+		Priority:   svr.Priority,
+		ManualStop: svr.ManualStop,
+		Restarts:   svr.Restarts,
+		Fatal:      svr.Fatal,
+		Usage:      ru,
+		Tree:       pt,
+	}
+	if svr.ExitTime > 0 {
+		code := svr.ExitCode
+		st.ExitCode = &code
+		st.ExitTime = svr.ExitTime
+	}
+	return st
+}
+
+// psSvr 显示服务的进程树
+func psSvr(name string, svr *model.ServiceParams) string {
+	pid, _, ok := svrIsRunning(svr)
+	if !ok {
+		return formatOutput(name, "PS", "not running")
+	}
+	procs := model.ScanProcs()
+	pt := svrTree(pid, svrChildren(name, pid, procs), procs)
+	if pt == nil {
+		return formatOutput(name, "PS", "not running")
+	}
+	return formatOutput(name, "PS", pt.Render())
+}
+
+func svrTree(pid int, children []*model.ProcStat, procs map[int]*model.ProcStat) *model.ProcNode {
+	p, ok := procs[pid]
+	if !ok {
+		return nil
+	}
+	return model.BuildProcTree(p, children)
+}
+
+// childrenOutput 列出服务的子进程，包括被重新挂到ssdctld下的孤儿进程
+func childrenOutput(cs []*model.ProcStat) string {
+	if len(cs) == 0 {
+		return ""
+	}
+	sort.Slice(cs, func(i, j int) bool { return cs[i].Pid < cs[j].Pid })
 	ss := strings.Builder{}
-	b, err := yaml.Marshal(svr)
+	for _, c := range cs {
+		cmd, _ := os.ReadFile(fmt.Sprintf("/proc/%d/cmdline", c.Pid))
+		cl := strings.TrimSpace(strings.ReplaceAll(string(cmd), "\x00", " "))
+		if cl == "" {
+			cl = "[" + c.Comm + "]"
+		}
+		ss.WriteString(fmt.Sprintf("\n|- %d\t%s", c.Pid, cl))
+	}
+	return ss.String()
+}
+
+// listSvr 服务的配置、环境变量和进程，effective为false时显示配置文件的原文，
+// 为true时显示合并_defaults.yaml和extends后的配置
+func listSvr(name string, svr *model.ServiceParams, effective bool) string {
+	ss := strings.Builder{}
+	var b []byte
+	var err error
+	if !effective {
+		b, err = os.ReadFile(filepath.Join(cnfdir, name+".yaml"))
+		b = model.RedactYAML(b)
+	}
+	if effective || err != nil {
+		b, err = yaml.Marshal(svr.Redacted())
+	}
 	if err != nil {
 		return formatOutput(name, "CONFIG", "config data error, use `update` command to reload all config. "+err.Error())
 	}
 	ss.WriteString(formatOutput(name, "", string(b)))
+	if env, err := buildEnv(svr); err != nil {
+		ss.WriteString(formatOutput("", "ENV", "error: "+err.Error()) + "\n")
+	} else {
+		ss.WriteString(formatOutput("", "ENV", strings.Join(model.RedactEnv(env), "\n")) + "\n")
+	}
 	_, ps, ok := svrIsRunning(svr)
 	if !ok {
 		ss.WriteString(formatOutput("", "PS", "not running"))
@@ -546,51 +994,32 @@ func startSvrFork(name string, svr *model.ServiceParams) (string, bool) {
 		return formatOutput(name, "START", "still running") + "\n" + formatOutput(name, "PS", ps), false // "[START\t" + name + "] is still running\n[PS] " + name + ":\n" + ps, false
 	}
 	var pid int
-	var err error
+	// 设置环境变量
+	env, err := buildEnv(svr)
+	if err != nil {
+		emit(model.NewEvent(model.EventStartFailed, name, 0, err.Error()))
+		return formatOutput(name, "START", "error: "+err.Error()), false
+	}
+	lookup := envLookup(env)
+	// 设置目录
+	if svr.Dir == "" {
+		svr.Dir = filepath.Dir(svr.Exec)
+	}
+	svr.Dir = model.ExpandEnv(svr.Dir, lookup)
 	// 准备替换内容
-	parmrepl := strings.NewReplacer()
-	if len(svr.Replace) > 0 {
-		xss := []string{}
-		for _, v := range svr.Replace {
-			if !strings.HasPrefix(v, "$") {
-				continue
-			}
-			ss := strings.Split(v, "=")
-			if len(ss) != 2 {
-				continue
-			}
-			yss := strings.Split(ss[1], " ")
-			cmd := exec.Command(yss[0], yss[1:]...)
-			b, err := cmd.CombinedOutput()
-			if err == nil {
-				xss = append(xss, ss[0], strings.TrimSpace(string(b)))
-			}
-		}
-		if len(xss) > 0 {
-			parmrepl = strings.NewReplacer(xss...)
-		}
+	parmrepl, err := resolveReplace(name, svr, env)
+	if err != nil {
+		emit(model.NewEvent(model.EventStartFailed, name, 0, err.Error()))
+		return formatOutput(name, "START", "error: "+err.Error()), false
 	}
 	params := []string{svr.Exec} // 使用syscall时，第一个需要进程名，使用exec.cmd时不需要
-	if len(svr.Params) > 0 {
-		if len(svr.Replace) == 0 {
-			params = append(params, svr.Params...)
+	for _, v := range svr.Params {
+		if strings.Contains(v, "$") {
+			params = append(params, parmrepl.Replace(model.ExpandEnv(v, lookup)))
 		} else {
-			for _, v := range svr.Params {
-				if strings.Contains(v, "$") {
-					params = append(params, parmrepl.Replace(v))
-				} else {
-					params = append(params, v)
-				}
-			}
+			params = append(params, v)
 		}
 	}
-	// 设置目录
-	if svr.Dir == "" {
-		svr.Dir = filepath.Dir(svr.Exec)
-	}
-	// 设置环境变量
-	env := os.Environ()
-	env = append(env, svr.Env...)
 	// 开始进程
 	cmd := exec.Command(svr.Exec, params[1:]...)
 	cmd.Dir = svr.Dir
@@ -599,35 +1028,71 @@ func startSvrFork(name string, svr *model.ServiceParams) (string, bool) {
 		Setpgid: true,
 		// Setsid: true,
 	}
+	var master, slave *os.File
+	if svr.Console { // 分配伪终端，使用新的会话，进程组id仍等于pid
+		master, slave, err = model.OpenPty()
+		if err != nil {
+			return formatOutput(name, "START", "error: open pty "+err.Error()), false
+		}
+		_ = model.SetWinsize(master.Fd(), 24, 80)
+		cmd.Stdin, cmd.Stdout, cmd.Stderr = slave, slave, slave
+		cmd.SysProcAttr = &syscall.SysProcAttr{
+			Setsid:  true,
+			Setctty: true,
+		}
+	}
 	// 开始执行
 	err = cmd.Start()
+	if slave != nil {
+		slave.Close()
+	}
 	if err != nil {
+		if master != nil {
+			master.Close()
+		}
+		emit(model.NewEvent(model.EventStartFailed, name, 0, err.Error()))
 		return formatOutput(name, "START", "error: "+err.Error()+" '"+svr.Exec+"'"), false // "[START\t" + name + "] error: " + err.Error() + " '" + svr.Exec + "'", false
 	}
-	go func() { _ = cmd.Wait() }()
+	if master != nil {
+		startConsole(name, master)
+	}
 	pid = cmd.Process.Pid
+	trackMain(pid, name)
+	_ = cmd.Process.Release() // 退出状态由reapLoop收割
 	time.Sleep(time.Second * time.Duration(svr.StartSec))
 	if !model.ProcessExist(pid) {
 		spid, _, ok = svrIsRunning(svr)
 		if !ok {
+			emit(model.NewEvent(model.EventStartFailed, name, pid, "exited within startsec"))
 			return formatOutput(name, "START", "failed"), false // + "\n" + formatOutput(name, "CMD", svr.Exec+" "+strings.Join(svr.Params, " ")), false // "[START\t" + name + "] failed" + "\n[CMD\t" + name + "]:\n" + svr.Exec + " " + strings.Join(svr.Params, " "), false
 		}
 		pid = spid
 	}
 	_ = allconf.SetRuntime(name, pid, false)
 	os.WriteFile(filepath.Join(piddir, name+".pid"), fmt.Appendf([]byte{}, "%d", pid), 0o664)
+	emit(model.NewEvent(model.EventStarted, name, pid, ""))
+	svrRecovered(name, pid)
 	return formatOutput(name, "START", "done, PID: "+strconv.Itoa(pid)), true // "[START\t" + name + "]\ndone. PID: " + fmt.Sprintf("%d", pid) + "\n[CMD] " + svr.Exec + " " + strings.Join(svr.Params, " "), true
 }
 
-func stopSvrFork(name string, svr *model.ServiceParams) string {
+func stopSvrFork(name string, svr *model.ServiceParams) (string, bool) {
 	pid, _, ok := svrIsRunning(svr)
 	if !ok {
-		return formatOutput(name, "STOP", "not running") //"[STOP\t" + name + "]:\nnot running"
+		return formatOutput(name, "STOP", "not running"), true //"[STOP\t" + name + "]:\nnot running"
 	}
 
-	err := syscall.Kill(pid, syscall.SIGINT)
+	// 主进程是进程组组长时向整个进程组发信号，同时记下子进程，主进程退出后一并清理
+	procs := model.ScanProcs()
+	children := svrChildren(name, pid, procs)
+	target := pid
+	if svrPgid(pid, procs) == pid {
+		target = -pid
+	}
+	_ = allconf.SetRuntime(name, pid, true) // 先标记为手动停止，收割时不当作异常退出
+	err := syscall.Kill(target, syscall.SIGINT)
 	if err != nil {
-		return formatOutput(name, "STOP", "error: "+err.Error()) //"[STOP\t" + name + "] error:\n" + err.Error()
+		_ = allconf.SetRuntime(name, svr.Pid, svr.ManualStop)
+		return formatOutput(name, "STOP", "error: "+err.Error()), false //"[STOP\t" + name + "] error:\n" + err.Error()
 	}
 	// if svr.Pid == 0 {
 	// 	go func(pid int) {
@@ -640,12 +1105,18 @@ func stopSvrFork(name string, svr *model.ServiceParams) string {
 			goto GOON
 		}
 	}
-	syscall.Kill(pid, syscall.SIGKILL)
+	syscall.Kill(target, syscall.SIGKILL)
 GOON:
+	for _, c := range children {
+		if model.ProcessExist(c.Pid) {
+			syscall.Kill(c.Pid, syscall.SIGKILL)
+		}
+	}
 	_ = allconf.SetRuntime(name, 0, true)
 	os.Remove(filepath.Join(piddir, name+".pid"))
+	emit(model.NewEvent(model.EventStopped, name, pid, ""))
 	time.Sleep(time.Millisecond * 200)
-	return formatOutput(name, "STOP", "done, PID: "+fmt.Sprintf("%d", pid)) // "[STOP\t" + name + "]:\ndone, PID: " + fmt.Sprintf("%d", pid)
+	return formatOutput(name, "STOP", "done, PID: "+fmt.Sprintf("%d", pid)), true // "[STOP\t" + name + "]:\ndone, PID: " + fmt.Sprintf("%d", pid)
 }
 
 func formatOutput(name, do, body string) string {
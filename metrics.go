@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	model "extsvr/model"
+)
+
+var (
+	requestCounts  [256]atomic.Uint64
+	scanTotal      atomic.Uint64
+	scanDuration   atomic.Int64 // 最近一次keepalive检查耗时，纳秒
+	scanDurationTo atomic.Int64 // keepalive检查总耗时，纳秒
+)
+
+func countRequest(do model.Jobs) {
+	requestCounts[do].Add(1)
+}
+
+func countScan(d time.Duration) {
+	scanTotal.Add(1)
+	scanDuration.Store(int64(d))
+	scanDurationTo.Add(int64(d))
+}
+
+// startMetrics 启动prometheus metrics服务
+func startMetrics(addr string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		w.Write([]byte(metricsText()))
+	})
+	go func() {
+		stdlog.Info("start metrics server: " + addr)
+		if err := http.ListenAndServe(addr, mux); err != nil {
+			stdlog.Error("metrics server error: " + err.Error())
+		}
+	}()
+}
+
+type metricFamily struct {
+	name  string
+	help  string
+	mtype string
+	lines []string
+}
+
+func (mf *metricFamily) add(labels string, v any) {
+	if labels != "" {
+		labels = "{" + labels + "}"
+	}
+	mf.lines = append(mf.lines, fmt.Sprintf("%s%s %v", mf.name, labels, v))
+}
+
+func metricsText() string {
+	families := []*metricFamily{
+		{name: "ssdctld_service_up", help: "Whether the service is running.", mtype: "gauge"},
+		{name: "ssdctld_service_enabled", help: "Whether the service is enabled.", mtype: "gauge"},
+		{name: "ssdctld_service_restarts_total", help: "Restarts by the keepalive loop.", mtype: "counter"},
+		{name: "ssdctld_service_last_exit_code", help: "Exit code of the last main process exit, 128+signal if killed by a signal.", mtype: "gauge"},
+		{name: "ssdctld_service_start_time_seconds", help: "Start time of the main process since unix epoch.", mtype: "gauge"},
+		{name: "ssdctld_service_cpu_seconds_total", help: "User and system cpu time of the service process group.", mtype: "counter"},
+		{name: "ssdctld_service_rss_bytes", help: "Resident memory of the service process group.", mtype: "gauge"},
+	}
+	procs := model.ScanProcs()
+	allconf.ForEach(func(key string, value *model.ServiceParams) bool {
+		lb := `service="` + escapeLabel(key) + `"`
+		pid, _, ok := svrIsRunning(value)
+		families[0].add(lb, boolMetric(ok))
+		families[1].add(lb, boolMetric(value.Enable))
+		families[2].add(lb, value.Restarts)
+		if value.ExitTime > 0 {
+			families[3].add(lb, value.ExitCode)
+		}
+		if !ok {
+			return true
+		}
+		p, found := procs[pid]
+		if !found {
+			return true
+		}
+		ru := model.ProcUsage(p, svrChildren(key, pid, procs))
+		families[4].add(lb, ru.StartTime)
+		families[5].add(lb, ru.CPUSeconds)
+		families[6].add(lb, ru.RSS)
+		return true
+	})
+	scan := &metricFamily{name: "ssdctld_keepalive_scan_duration_seconds", help: "Duration of the last keepalive scan.", mtype: "gauge"}
+	scan.add("", time.Duration(scanDuration.Load()).Seconds())
+	scanSum := &metricFamily{name: "ssdctld_keepalive_scan_seconds_total", help: "Total duration of keepalive scans.", mtype: "counter"}
+	scanSum.add("", time.Duration(scanDurationTo.Load()).Seconds())
+	scans := &metricFamily{name: "ssdctld_keepalive_scans_total", help: "Keepalive scans.", mtype: "counter"}
+	scans.add("", scanTotal.Load())
+	reqs := &metricFamily{name: "ssdctld_control_requests_total", help: "Control requests handled, by job type.", mtype: "counter"}
+	for i := range requestCounts {
+		if n := requestCounts[i].Load(); n > 0 {
+			reqs.add(`job="`+model.Jobs(i).String()+`"`, n)
+		}
+	}
+	families = append(families, scan, scanSum, scans, reqs)
+	ss := strings.Builder{}
+	for _, mf := range families {
+		ss.WriteString("# HELP " + mf.name + " " + mf.help + "\n")
+		ss.WriteString("# TYPE " + mf.name + " " + mf.mtype + "\n")
+		for _, l := range mf.lines {
+			ss.WriteString(l + "\n")
+		}
+	}
+	return ss.String()
+}
+
+func boolMetric(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func escapeLabel(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s)
+}
@@ -0,0 +1,53 @@
+package model
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// AuditRecord 一条控制命令的审计记录
+type AuditRecord struct {
+	Time   int64  `json:"time"`
+	Uid    int    `json:"uid"`
+	Gid    int    `json:"gid"`
+	Pid    int    `json:"pid"`
+	User   string `json:"user,omitempty"`
+	Peer   string `json:"peer,omitempty"`
+	Source string `json:"source,omitempty"` // api或dashboard，为空时是ssdctl
+	ToDo   *ToDo  `json:"todo"`
+	Result string `json:"result,omitempty"`
+}
+
+// String 单行的可读格式
+func (a *AuditRecord) String() string {
+	ss := strings.Builder{}
+	ss.WriteString(time.Unix(a.Time, 0).Format("2006-01-02 15:04:05"))
+	ss.WriteString("  uid=" + strconv.Itoa(a.Uid))
+	if a.User != "" {
+		ss.WriteString("(" + a.User + ")")
+	}
+	ss.WriteString(" gid=" + strconv.Itoa(a.Gid) + " pid=" + strconv.Itoa(a.Pid))
+	if a.Source != "" {
+		ss.WriteString(" via " + a.Source)
+		if a.Peer != "" {
+			ss.WriteString("(" + a.Peer + ")")
+		}
+	}
+	if a.ToDo != nil {
+		ss.WriteString("  " + a.ToDo.Do.String())
+		if a.ToDo.Name != "" {
+			ss.WriteString(" " + a.ToDo.Name)
+		}
+		if a.ToDo.Exec != "" {
+			ss.WriteString(" " + a.ToDo.Exec)
+		}
+		if len(a.ToDo.Params) > 0 {
+			ss.WriteString(" " + strings.Join(a.ToDo.Params, " "))
+		}
+	}
+	if a.Result != "" {
+		ss.WriteString("  => " + a.Result)
+	}
+	return ss.String()
+}
@@ -0,0 +1,153 @@
+package model
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ConfigError 一个配置文件的问题，Skipped为true时该文件没有加载
+type ConfigError struct {
+	File    string   `json:"file"`
+	Errors  []string `json:"errors"`
+	Skipped bool     `json:"skipped,omitempty"`
+}
+
+func (e *ConfigError) Error() string {
+	s := e.File + ": " + strings.Join(e.Errors, "; ")
+	if e.Skipped {
+		s += " (skipped)"
+	}
+	return s
+}
+
+// CheckName 检查服务名，服务名用作cnf.d中的文件名，不能包含路径、空白，不能以.或_开头，也不能是命令关键字
+func CheckName(name string) error {
+	if name == "" || IsReservedName(name) || IsTemplate(name) || strings.HasPrefix(name, ".") ||
+		strings.Contains(name, "..") || strings.ContainsAny(name, "/\\ \t\r\n") {
+		return errors.New("can not use `" + name + "` as application's name")
+	}
+	return nil
+}
+
+// ExecPath 启动时执行的文件，不含/的命令由启动时在PATH中查找，原样返回，
+// 相对路径相对于服务的dir，没有设置dir时相对于当前目录
+func ExecPath(svr *ServiceParams) string {
+	if !strings.Contains(svr.Exec, "/") || filepath.IsAbs(svr.Exec) {
+		return svr.Exec
+	}
+	p := svr.Exec
+	if svr.Dir != "" && !strings.Contains(svr.Dir, "$") {
+		p = filepath.Join(svr.Dir, p)
+	}
+	if x, err := filepath.Abs(p); err == nil {
+		return x
+	}
+	return p
+}
+
+// CheckService 检查服务配置的内容，需要在ensureDefault之前调用
+func CheckService(name string, svr *ServiceParams, cnfdir string) []string {
+	errs := make([]string, 0)
+	if err := CheckName(name); err != nil {
+		errs = append(errs, err.Error())
+	}
+	switch {
+	case svr.Exec == "":
+		errs = append(errs, "exec is empty")
+	case !strings.Contains(svr.Exec, "/"): // 启动时在PATH中查找
+		if _, err := exec.LookPath(svr.Exec); err != nil {
+			errs = append(errs, "exec `"+svr.Exec+"` not found in PATH")
+		}
+	case strings.Contains(svr.Dir, "$") && !filepath.IsAbs(svr.Exec): // 目录中的变量只在启动时展开
+	default:
+		fi, err := os.Stat(ExecPath(svr))
+		switch {
+		case err != nil:
+			errs = append(errs, "exec `"+svr.Exec+"` not found")
+		case fi.IsDir() || fi.Mode()&0o111 == 0:
+			errs = append(errs, "exec `"+svr.Exec+"` is not executable")
+		}
+	}
+	switch svr.OnConfigChange {
+	case "", OnChangeNone, OnChangeRestart:
+	default:
+		errs = append(errs, "on_config_change should be `restart` or `none`")
+	}
+	if svr.Priority > 255 {
+		errs = append(errs, "priority "+strconv.FormatUint(uint64(svr.Priority), 10)+" out of range 1-255")
+	}
+	for _, v := range svr.Env {
+		k, _, ok := strings.Cut(v, "=")
+		if !ok || k == "" || strings.ContainsAny(k, " \t") {
+			errs = append(errs, "env `"+v+"` should be 'key=value' format")
+		}
+	}
+	for _, f := range svr.EnvFiles {
+		optional := strings.HasPrefix(f, "-")
+		f = strings.TrimPrefix(f, "-")
+		if strings.Contains(f, "$") { // 包含变量时只在启动时检查
+			continue
+		}
+		if !filepath.IsAbs(f) {
+			f = filepath.Join(cnfdir, f)
+		}
+		if _, err := ReadEnvFile(f); err != nil && !(optional && os.IsNotExist(err)) {
+			errs = append(errs, "env_files: "+err.Error())
+		}
+	}
+	keys := make(map[string]bool)
+	for _, rv := range svr.Replace {
+		if err := rv.Check(); err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		if _, err := SplitArgs(rv.Cmd); err != nil {
+			errs = append(errs, "replace `"+rv.Key+"`: "+err.Error())
+		}
+		if keys[rv.Key] {
+			errs = append(errs, "replace `"+rv.Key+"` is duplicated")
+		}
+		keys[rv.Key] = true
+	}
+	return errs
+}
+
+// unknownKeys 检查yaml中不属于t的字段，replace中的完整写法也一并检查，key+表示追加到继承的列表
+func unknownKeys(n *yaml.Node, t reflect.Type, errs []string) []string {
+	if n.Kind != yaml.MappingNode {
+		return errs
+	}
+	fields := make(map[string]reflect.Type)
+	for i := range t.NumField() {
+		f := t.Field(i)
+		tag, _, _ := strings.Cut(f.Tag.Get("yaml"), ",")
+		if tag != "" && tag != "-" {
+			fields[tag] = f.Type
+		}
+	}
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		k, v := n.Content[i], n.Content[i+1]
+		key, appendList := strings.CutSuffix(k.Value, "+")
+		ft, ok := fields[key]
+		if !ok {
+			errs = append(errs, "line "+strconv.Itoa(k.Line)+": unknown key `"+k.Value+"`")
+			continue
+		}
+		if appendList && ft.Kind() != reflect.Slice {
+			errs = append(errs, "line "+strconv.Itoa(k.Line)+": `"+key+"` is not a list, can not use `"+k.Value+"`")
+		}
+		if ft == reflect.TypeFor[[]ReplaceVar]() && v.Kind == yaml.SequenceNode {
+			for _, x := range v.Content {
+				errs = unknownKeys(x, reflect.TypeFor[ReplaceVar](), errs)
+			}
+		}
+	}
+	return errs
+}
@@ -0,0 +1,57 @@
+package model
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestCheckServiceExec(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "run.sh"), []byte("#!/bin/sh\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "data.txt"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		name string
+		svr  *ServiceParams
+		want []string
+	}{
+		{name: "name in PATH", svr: &ServiceParams{Exec: "sh"}},
+		{name: "absolute", svr: &ServiceParams{Exec: "/bin/sh"}},
+		{name: "missing in PATH", svr: &ServiceParams{Exec: "no-such-cmd-x"}, want: []string{"exec `no-such-cmd-x` not found in PATH"}},
+		{name: "relative to dir", svr: &ServiceParams{Exec: "./run.sh", Dir: dir}},
+		{name: "relative to missing", svr: &ServiceParams{Exec: "./nope.sh", Dir: dir}, want: []string{"exec `./nope.sh` not found"}},
+		{name: "dir with variable", svr: &ServiceParams{Exec: "./run.sh", Dir: "$HOME/x"}},
+		{name: "not executable", svr: &ServiceParams{Exec: filepath.Join(dir, "data.txt")}, want: []string{"exec `" + filepath.Join(dir, "data.txt") + "` is not executable"}},
+		{name: "directory", svr: &ServiceParams{Exec: dir}, want: []string{"exec `" + dir + "` is not executable"}},
+	}
+	for _, c := range cases {
+		got := CheckService("app", c.svr, dir)
+		if !slices.Equal(got, c.want) && len(got)+len(c.want) > 0 {
+			t.Errorf("%s: got %q, want %q", c.name, got, c.want)
+		}
+	}
+}
+
+func TestEnsureDefaultExec(t *testing.T) {
+	c := NewCnf(t.TempDir(), t.TempDir())
+	cases := []struct {
+		exec, dir string
+		want      string
+	}{
+		{"sh", "", "sh"},
+		{"/bin/sh", "", "/bin/sh"},
+		{"./run.sh", "/opt/a", "/opt/a/run.sh"},
+		{"bin/run", "/opt/a", "/opt/a/bin/run"},
+		{"./run.sh", "$HOME", "./run.sh"},
+	}
+	for _, x := range cases {
+		if got := c.ensureDefault(&ServiceParams{Exec: x.exec, Dir: x.dir}).Exec; got != x.want {
+			t.Errorf("ensureDefault(%s, %s).Exec = %s, want %s", x.exec, x.dir, got, x.want)
+		}
+	}
+}
@@ -1,13 +1,18 @@
 package model
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"os"
 	"path/filepath"
+	"reflect"
 	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/xyzj/toolbox/pathtool"
 	"gopkg.in/yaml.v3"
@@ -18,6 +23,8 @@ type Config struct {
 	data   map[string]*ServiceParams
 	cnfdir string
 	piddir string
+	// 运行时状态文件
+	statefile string
 }
 
 func cloneServiceParams(src *ServiceParams) *ServiceParams {
@@ -26,8 +33,11 @@ func cloneServiceParams(src *ServiceParams) *ServiceParams {
 	}
 	dst := *src
 	dst.Params = append([]string(nil), src.Params...)
-	dst.Replace = append([]string(nil), src.Replace...)
+	dst.Replace = append([]ReplaceVar(nil), src.Replace...)
 	dst.Env = append([]string(nil), src.Env...)
+	dst.EnvFiles = append([]string(nil), src.EnvFiles...)
+	dst.Notify = append([]string(nil), src.Notify...)
+	dst.Tags = append([]string(nil), src.Tags...)
 	return &dst
 }
 
@@ -41,11 +51,9 @@ func NewCnf(cnf, pid string) *Config {
 }
 
 func (c *Config) ensureDefault(svr *ServiceParams) *ServiceParams {
-	if !filepath.IsAbs(svr.Exec) {
-		x, err := filepath.Abs(svr.Exec)
-		if err == nil {
-			svr.Exec = x
-		}
+	// 和检查时一致，相对路径相对于dir，不含/的命令启动时在PATH中查找
+	if !strings.Contains(svr.Dir, "$") {
+		svr.Exec = ExecPath(svr)
 	}
 	if svr.Priority == 0 {
 		svr.Priority = 200
@@ -61,50 +69,78 @@ func (c *Config) Len() int {
 	return len(c.data)
 }
 
-func (c *Config) FromFiles() {
-	c.locker.Lock()
-	defer c.locker.Unlock()
-	fsd, err := os.ReadDir(c.cnfdir)
+// FromFiles 重新读取cnf.d并应用，返回每个文件的问题，
+// 格式错误的文件不加载，如果该服务已经存在则保留之前的配置
+func (c *Config) FromFiles() []*ConfigError {
+	data, errs := c.ReadFiles()
+	c.Replace(data)
+	return errs
+}
+
+// ReadFiles 读取cnf.d但不应用，格式错误的文件保留当前的配置
+func (c *Config) ReadFiles() (map[string]*ServiceParams, []*ConfigError) {
+	c.locker.RLock()
+	defer c.locker.RUnlock()
+	data, errs, err := LoadDir(c.cnfdir, nil)
 	if err != nil {
-		println(c.cnfdir + " - " + err.Error())
-		return
-	}
-	if c.data == nil {
-		c.data = make(map[string]*ServiceParams)
-	} else {
-		for k := range c.data {
-			delete(c.data, k)
+		// 目录无法读取时不改变当前的配置
+		data = make(map[string]*ServiceParams)
+		for k, v := range c.data {
+			data[k] = cloneServiceParams(v)
 		}
+		return data, []*ConfigError{{File: c.cnfdir, Errors: []string{err.Error()}, Skipped: true}}
 	}
-	for _, fs := range fsd {
-		if fs.IsDir() {
+	for _, e := range errs {
+		if !e.Skipped {
 			continue
 		}
-		if filepath.Ext(fs.Name()) != ".yaml" {
-			continue
-		}
-		b, err := os.ReadFile(filepath.Join(c.cnfdir, fs.Name()))
-		if err != nil {
-			println(fs.Name() + " - " + err.Error())
-			continue
+		svrname := strings.TrimSuffix(e.File, ".yaml")
+		if o, ok := c.data[svrname]; ok {
+			e.Errors = append(e.Errors, "keep the previous config")
+			data[svrname] = cloneServiceParams(o)
 		}
-		s := &ServiceParams{}
-		err = yaml.Unmarshal(b, s)
-		if err != nil {
-			println(fs.Name() + " - " + err.Error())
-			continue
+	}
+	for k, s := range data {
+		data[k] = c.ensureDefault(s)
+	}
+	return data, errs
+}
+
+// Snapshot 当前所有服务配置的副本，包含运行时状态
+func (c *Config) Snapshot() map[string]*ServiceParams {
+	c.locker.RLock()
+	defer c.locker.RUnlock()
+	data := make(map[string]*ServiceParams, len(c.data))
+	for k, v := range c.data {
+		data[k] = cloneServiceParams(v)
+	}
+	return data
+}
+
+// Replace 使用新的配置，已有的服务保留运行时状态，pid以pid.d中的为准
+func (c *Config) Replace(data map[string]*ServiceParams) {
+	c.locker.Lock()
+	defer c.locker.Unlock()
+	old := c.data
+	c.data = make(map[string]*ServiceParams, len(data))
+	for svrname, s := range data {
+		if o, ok := old[svrname]; ok { // 重新加载时保留运行时状态
+			copyRuntime(s, o)
 		}
-		svrname := strings.TrimSuffix(fs.Name(), ".yaml")
 		if b, err := os.ReadFile(filepath.Join(c.piddir, svrname+".pid")); err == nil {
 			pidstr := strings.TrimSpace(string(b))
 			pid, _ := strconv.Atoi(pidstr)
 			s.Pid = pid
 		}
-		c.data[svrname] = c.ensureDefault(s)
+		c.data[svrname] = s
 	}
+	c.saveState()
 }
 
 func (c *Config) AddItem(name string, svr *ServiceParams) error {
+	if err := CheckName(name); err != nil {
+		return err
+	}
 	c.locker.Lock()
 	defer c.locker.Unlock()
 	_, ok := c.data[name]
@@ -124,6 +160,148 @@ func (c *Config) AddItem(name string, svr *ServiceParams) error {
 	return nil
 }
 
+// UpdateContent 返回把svr中和运行中配置不同的字段写入后的配置文件内容，不写入文件，
+// 没有修改的字段、extends、key+和注释保持不变，清空的字段在有继承时写为null，避免继承的值生效
+func (c *Config) UpdateContent(name string, svr *ServiceParams) ([]byte, error) {
+	old, ok := c.GetItem(name)
+	if !ok {
+		return nil, errors.New("service " + name + " not exist")
+	}
+	doc, err := c.rawItem(name)
+	if err != nil {
+		return nil, err
+	}
+	if doc == nil { // 文件已被删除
+		return yaml.Marshal(svr)
+	}
+	root := doc.Content[0]
+	inherit := pathtool.IsExist(filepath.Join(c.cnfdir, DefaultsName+".yaml"))
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		inherit = inherit || root.Content[i].Value == "extends"
+	}
+	nv, ov := reflect.ValueOf(svr).Elem(), reflect.ValueOf(old).Elem()
+	cv := reflect.ValueOf(c.ensureDefault(cloneServiceParams(svr))).Elem()
+	for i := range nv.NumField() {
+		key, _, _ := strings.Cut(nv.Type().Field(i).Tag.Get("yaml"), ",")
+		if key == "" || key == "-" || sameField(cv.Field(i), ov.Field(i)) {
+			continue
+		}
+		switch {
+		case !emptyField(nv.Field(i)):
+			v := &yaml.Node{}
+			if err := v.Encode(nv.Field(i).Interface()); err != nil {
+				return nil, err
+			}
+			setMappingNode(root, key, v)
+		case inherit:
+			setMappingNode(root, key, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!null", Value: "null"})
+		default:
+			delMappingKey(root, key)
+		}
+	}
+	return encodeDoc(doc)
+}
+
+// EditData ssdctl edit读取和提交的配置文件内容，Sum为读取时文件内容的sha256，用于发现期间文件被修改
+type EditData struct {
+	Content string `json:"content"`
+	Sum     string `json:"sum"`
+}
+
+// ContentSum 配置文件内容的sha256
+func ContentSum(b []byte) string {
+	x := sha256.Sum256(b)
+	return hex.EncodeToString(x[:])
+}
+
+// CheckItem 检查服务配置文件的新内容，_defaults.yaml和extends使用cnf.d中的其他文件，不写入文件。
+// 和修改前相比新出现的问题，包括继承了该服务的其他配置因此产生的问题，或者文件无法加载时拒绝修改，返回nil；
+// 修改前就有、reload也会照常加载的问题作为警告和配置一起返回
+func (c *Config) CheckItem(name string, b []byte) (*ServiceParams, *ConfigError) {
+	file := name + ".yaml"
+	data, errs, err := LoadDir(c.cnfdir, map[string][]byte{name: b})
+	if err != nil {
+		return nil, &ConfigError{File: file, Errors: []string{err.Error()}, Skipped: true}
+	}
+	ce := &ConfigError{File: file, Errors: make([]string, 0)}
+	warn := &ConfigError{File: file, Errors: make([]string, 0)}
+	if len(errs) > 0 {
+		// 只有这一个文件不同，和修改前相比新出现的问题都是由它引起的
+		_, before, _ := LoadDir(c.cnfdir, nil)
+		known := make(map[string]bool)
+		for _, e := range before {
+			if e.File != file {
+				known[e.Error()] = true
+				continue
+			}
+			for _, x := range e.Errors {
+				known[trimLine(x)] = true
+			}
+		}
+		for _, e := range errs {
+			switch {
+			case e.File == file:
+				for _, x := range e.Errors {
+					if e.Skipped || !known[trimLine(x)] {
+						ce.Errors = append(ce.Errors, x)
+					} else {
+						warn.Errors = append(warn.Errors, x)
+					}
+				}
+				ce.Skipped = e.Skipped
+			case !known[e.Error()]:
+				ce.Errors = append(ce.Errors, e.File+": "+strings.Join(e.Errors, "; "))
+				ce.Skipped = ce.Skipped || e.Skipped
+			}
+		}
+	}
+	if len(ce.Errors) > 0 {
+		ce.Errors = append(ce.Errors, warn.Errors...)
+		return nil, ce
+	}
+	s, ok := data[name]
+	if !ok {
+		return nil, &ConfigError{File: file, Errors: []string{"`" + name + "` is a template"}, Skipped: true}
+	}
+	if len(warn.Errors) == 0 {
+		warn = nil
+	}
+	return c.ensureDefault(s), warn
+}
+
+// trimLine 去掉问题前面的行号，修改内容后行号会变化
+func trimLine(s string) string {
+	if x, ok := strings.CutPrefix(s, "line "); ok {
+		if _, after, ok := strings.Cut(x, ": "); ok {
+			return after
+		}
+	}
+	return s
+}
+
+// WriteItem 用b替换服务的配置文件，先写入临时文件再改名，保留运行时状态
+func (c *Config) WriteItem(name string, svr *ServiceParams, b []byte) error {
+	c.locker.Lock()
+	defer c.locker.Unlock()
+	old, ok := c.data[name]
+	if !ok {
+		return errors.New("service " + name + " not exist")
+	}
+	s := cloneServiceParams(svr)
+	copyRuntime(s, old)
+	// 以.开头的临时文件不会触发cnf.d的监视
+	tmp := filepath.Join(c.cnfdir, "."+name+".yaml.tmp")
+	if err := os.WriteFile(tmp, b, 0o664); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, filepath.Join(c.cnfdir, name+".yaml")); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	c.data[name] = s
+	return nil
+}
+
 func (c *Config) DelItem(name string) error {
 	c.locker.Lock()
 	defer c.locker.Unlock()
@@ -131,6 +309,7 @@ func (c *Config) DelItem(name string) error {
 		return errors.New("service " + name + " not exist")
 	}
 	delete(c.data, name)
+	c.saveState()
 	err := os.Remove(filepath.Join(c.cnfdir, name+".yaml"))
 	if err != nil {
 		if strings.Contains(err.Error(), "no such file") {
@@ -159,8 +338,46 @@ func (c *Config) SetRuntime(name string, pid int, manualStop bool) error {
 	}
 	s.Pid = pid
 	s.ManualStop = manualStop
-	return nil
+	return c.saveState()
 }
+
+// SetExit 记录服务主进程的退出码
+func (c *Config) SetExit(name string, code int) error {
+	c.locker.Lock()
+	defer c.locker.Unlock()
+	s, ok := c.data[name]
+	if !ok {
+		return errors.New("service " + name + " not found")
+	}
+	s.ExitCode = code
+	s.ExitTime = time.Now().Unix()
+	return c.saveState()
+}
+
+// AddRestart keepalive重启次数+1
+func (c *Config) AddRestart(name string) error {
+	c.locker.Lock()
+	defer c.locker.Unlock()
+	s, ok := c.data[name]
+	if !ok {
+		return errors.New("service " + name + " not found")
+	}
+	s.Restarts++
+	return c.saveState()
+}
+
+// SetHealth 记录服务是否异常退出、keepalive连续启动失败次数以及是否已放弃重启(FATAL)
+func (c *Config) SetHealth(name string, crashed bool, fails uint32, fatal bool) error {
+	c.locker.Lock()
+	defer c.locker.Unlock()
+	s, ok := c.data[name]
+	if !ok {
+		return errors.New("service " + name + " not found")
+	}
+	s.Crashed, s.Fails, s.Fatal = crashed, fails, fatal
+	return c.saveState()
+}
+
 func (c *Config) SetLevel(name string, l uint32) error {
 	c.locker.Lock()
 	defer c.locker.Unlock()
@@ -169,11 +386,7 @@ func (c *Config) SetLevel(name string, l uint32) error {
 		return errors.New("service " + name + " not found")
 	}
 	s.Priority = max(min(l, 99), 1)
-	b, err := yaml.Marshal(s)
-	if err != nil {
-		return err
-	}
-	return os.WriteFile(filepath.Join(c.cnfdir, name+".yaml"), b, 0o664)
+	return c.patchItem(name, s, "priority", strconv.FormatUint(uint64(s.Priority), 10))
 }
 
 func (c *Config) SetEnable(name string, enable bool) error {
@@ -187,11 +400,83 @@ func (c *Config) SetEnable(name string, enable bool) error {
 		return nil
 	}
 	s.Enable = enable
-	b, err := yaml.Marshal(s)
+	return c.patchItem(name, s, "enable", strconv.FormatBool(enable))
+}
+
+// sameField 空列表和nil相同
+func sameField(a, b reflect.Value) bool {
+	if emptyField(a) && emptyField(b) {
+		return true
+	}
+	return reflect.DeepEqual(a.Interface(), b.Interface())
+}
+
+func emptyField(v reflect.Value) bool {
+	return v.IsZero() || (v.Kind() == reflect.Slice && v.Len() == 0)
+}
+
+// patchItem 只修改配置文件中的一个值，保留extends、key+和注释，不把合并后的配置写回文件，
+// 文件已被删除时写入运行中的配置
+func (c *Config) patchItem(name string, svr *ServiceParams, key, value string) error {
+	file := filepath.Join(c.cnfdir, name+".yaml")
+	doc, err := c.rawItem(name)
+	if err != nil {
+		return err
+	}
+	if doc == nil {
+		b, err := yaml.Marshal(svr)
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(file, b, 0o664)
+	}
+	setMappingValue(doc.Content[0], key, value)
+	b, err := encodeDoc(doc)
 	if err != nil {
 		return err
 	}
-	return os.WriteFile(filepath.Join(c.cnfdir, name+".yaml"), b, 0o664)
+	tmp := filepath.Join(c.cnfdir, "."+name+".yaml.tmp")
+	if err := os.WriteFile(tmp, b, 0o664); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, file); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// rawItem 读取服务配置文件的yaml文档，文件不存在时返回nil
+func (c *Config) rawItem(name string) (*yaml.Node, error) {
+	b, err := os.ReadFile(filepath.Join(c.cnfdir, name+".yaml"))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	doc := &yaml.Node{}
+	if err := yaml.Unmarshal(b, doc); err != nil {
+		return nil, err
+	}
+	if doc.Kind == 0 { // 空文件
+		doc = &yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{{Kind: yaml.MappingNode}}}
+	}
+	if doc.Content[0].Kind != yaml.MappingNode {
+		return nil, errors.New(name + ".yaml is not a mapping")
+	}
+	return doc, nil
+}
+
+func encodeDoc(doc *yaml.Node) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	enc := yaml.NewEncoder(buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(doc); err != nil {
+		return nil, err
+	}
+	enc.Close()
+	return buf.Bytes(), nil
 }
 
 func (c *Config) ForEach(f func(key string, value *ServiceParams) bool) {
@@ -0,0 +1,104 @@
+package model
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// 配置变化的类型
+const (
+	ChangeAdded   = "added"
+	ChangeRemoved = "removed"
+	ChangeChanged = "changed"
+)
+
+// on_config_change的取值
+const (
+	OnChangeNone    = "none"
+	OnChangeRestart = "restart"
+)
+
+// ServiceDiff 重新加载时一个服务的配置变化，Action为需要执行的start, stop或restart
+type ServiceDiff struct {
+	Name   string         `json:"name"`
+	Change string         `json:"change"`
+	Fields []string       `json:"fields,omitempty"`
+	Action string         `json:"action,omitempty"`
+	Old    *ServiceParams `json:"-"`
+	New    *ServiceParams `json:"-"`
+}
+
+func (d *ServiceDiff) String() string {
+	s := d.Name + " " + d.Change
+	if len(d.Fields) > 0 {
+		s += ": " + strings.Join(d.Fields, ", ")
+	}
+	return s
+}
+
+// Unified 运行中的配置和cnf.d中配置的unified diff，env中的密码等被隐藏
+func (d *ServiceDiff) Unified() string {
+	aName, bName := "running/"+d.Name, "cnf.d/"+d.Name+".yaml"
+	var a, b string
+	if d.Old != nil {
+		x, _ := yaml.Marshal(d.Old.Redacted())
+		a = string(x)
+	} else {
+		aName = "/dev/null"
+	}
+	if d.New != nil {
+		x, _ := yaml.Marshal(d.New.Redacted())
+		b = string(x)
+	} else {
+		bName = "/dev/null"
+	}
+	return UnifiedDiff(aName, bName, a, b)
+}
+
+// DiffServices 比较两组配置，返回有变化的服务，按服务名排序
+func DiffServices(old, cur map[string]*ServiceParams) []*ServiceDiff {
+	ds := make([]*ServiceDiff, 0)
+	for name, o := range old {
+		n, ok := cur[name]
+		if !ok {
+			ds = append(ds, &ServiceDiff{Name: name, Change: ChangeRemoved, Old: o})
+			continue
+		}
+		if fs := changedFields(o, n); len(fs) > 0 {
+			ds = append(ds, &ServiceDiff{Name: name, Change: ChangeChanged, Fields: fs, Old: o, New: n})
+		}
+	}
+	for name, n := range cur {
+		if _, ok := old[name]; !ok {
+			ds = append(ds, &ServiceDiff{Name: name, Change: ChangeAdded, New: n})
+		}
+	}
+	sort.Slice(ds, func(i, j int) bool {
+		return ds[i].Name < ds[j].Name
+	})
+	return ds
+}
+
+// changedFields 有变化的配置项，运行时状态不参与比较，空列表和nil相同
+func changedFields(a, b *ServiceParams) []string {
+	fs := make([]string, 0)
+	va, vb := reflect.ValueOf(a).Elem(), reflect.ValueOf(b).Elem()
+	t := va.Type()
+	for i := range t.NumField() {
+		tag, _, _ := strings.Cut(t.Field(i).Tag.Get("yaml"), ",")
+		if tag == "" || tag == "-" {
+			continue
+		}
+		x, y := va.Field(i), vb.Field(i)
+		if x.Kind() == reflect.Slice && x.Len() == 0 && y.Len() == 0 {
+			continue
+		}
+		if !reflect.DeepEqual(x.Interface(), y.Interface()) {
+			fs = append(fs, tag)
+		}
+	}
+	return fs
+}
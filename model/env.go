@@ -0,0 +1,178 @@
+package model
+
+import (
+	"bufio"
+	"errors"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ReadEnvFile 读取dotenv格式的文件，返回`key=value`列表
+func ReadEnvFile(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	env := make([]string, 0)
+	scanner := bufio.NewScanner(f)
+	ln := 0
+	for scanner.Scan() {
+		ln++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+		k, v, ok := strings.Cut(line, "=")
+		k = strings.TrimSpace(k)
+		if !ok || k == "" || strings.ContainsAny(k, " \t") {
+			return nil, errors.New(path + ":" + strconv.Itoa(ln) + ": should be 'key=value' format")
+		}
+		v, err = envValue(strings.TrimSpace(v))
+		if err != nil {
+			return nil, errors.New(path + ":" + strconv.Itoa(ln) + ": " + err.Error())
+		}
+		env = append(env, k+"="+v)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return env, nil
+}
+
+func envValue(v string) (string, error) {
+	if v == "" {
+		return v, nil
+	}
+	switch v[0] {
+	case '\'':
+		i := strings.IndexByte(v[1:], '\'')
+		if i < 0 {
+			return "", errors.New("unterminated quote")
+		}
+		return v[1 : i+1], nil
+	case '"':
+		b := strings.Builder{}
+		for i := 1; i < len(v); i++ {
+			switch v[i] {
+			case '"':
+				return b.String(), nil
+			case '\\':
+				if i+1 < len(v) {
+					i++
+					switch v[i] {
+					case 'n':
+						b.WriteByte('\n')
+					case 't':
+						b.WriteByte('\t')
+					default:
+						b.WriteByte(v[i])
+					}
+				}
+			default:
+				b.WriteByte(v[i])
+			}
+		}
+		return "", errors.New("unterminated quote")
+	}
+	// 未加引号时` #`之后是注释
+	if i := strings.Index(v, " #"); i >= 0 {
+		v = strings.TrimSpace(v[:i])
+	}
+	return v, nil
+}
+
+// DedupEnv 去掉重复的key，保留最后一次设置的值
+func DedupEnv(env []string) []string {
+	idx := make(map[string]int, len(env))
+	out := make([]string, 0, len(env))
+	for _, e := range env {
+		k, _, _ := strings.Cut(e, "=")
+		if i, ok := idx[k]; ok {
+			out[i] = e
+			continue
+		}
+		idx[k] = len(out)
+		out = append(out, e)
+	}
+	return out
+}
+
+// 变量名包含这些词时，显示给客户端的值会被隐藏
+var secretKey = regexp.MustCompile(`(?i)(pass|secret|token|key|credential|private)`)
+
+// RedactedValue 隐藏后的值
+const RedactedValue = "******"
+
+// RedactEnv 隐藏密码、token等变量的值
+func RedactEnv(env []string) []string {
+	out := make([]string, 0, len(env))
+	for _, v := range env {
+		k, _, _ := strings.Cut(v, "=")
+		if secretKey.MatchString(k) {
+			v = k + "=" + RedactedValue
+		}
+		out = append(out, v)
+	}
+	return out
+}
+
+// Redacted 显示给客户端的配置，env中的密码等被隐藏
+func (svr *ServiceParams) Redacted() *ServiceParams {
+	s := cloneServiceParams(svr)
+	if len(s.Env) > 0 {
+		s.Env = RedactEnv(s.Env)
+	}
+	return s
+}
+
+// Unredact 客户端提交的env中仍为隐藏值的变量使用old中的值，用于读取后修改再提交
+func (svr *ServiceParams) Unredact(old *ServiceParams) {
+	for i, v := range svr.Env {
+		k, x, _ := strings.Cut(v, "=")
+		if x != RedactedValue {
+			continue
+		}
+		for _, o := range old.Env {
+			if ok, _, _ := strings.Cut(o, "="); ok == k {
+				svr.Env[i] = o
+			}
+		}
+	}
+}
+
+// RedactYAML 隐藏配置文件原文中env和env+的密码等，无法解析时原样返回
+func RedactYAML(b []byte) []byte {
+	doc := &yaml.Node{}
+	if yaml.Unmarshal(b, doc) != nil || len(doc.Content) == 0 || doc.Content[0].Kind != yaml.MappingNode {
+		return b
+	}
+	n := doc.Content[0]
+	changed := false
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		if strings.TrimSuffix(n.Content[i].Value, "+") != "env" || n.Content[i+1].Kind != yaml.SequenceNode {
+			continue
+		}
+		for _, x := range n.Content[i+1].Content {
+			if x.Kind != yaml.ScalarNode {
+				continue
+			}
+			if v := RedactEnv([]string{x.Value})[0]; v != x.Value {
+				x.Value, x.Style, changed = v, 0, true
+			}
+		}
+	}
+	if !changed {
+		return b
+	}
+	out, err := yaml.Marshal(doc)
+	if err != nil {
+		return b
+	}
+	return out
+}
@@ -0,0 +1,70 @@
+package model
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 事件类型
+const (
+	EventStarted       = "started"
+	EventStartFailed   = "start_failed"
+	EventStopped       = "stopped"
+	EventExited        = "exited"
+	EventRestarted     = "restarted"
+	EventRestartFailed = "restart_failed" // keepalive重启失败
+	EventReloaded      = "reloaded"
+	EventEnabled       = "enabled"
+	EventDisabled      = "disabled"
+	EventCrashed       = "crashed"
+	EventFatal         = "fatal"
+	EventRecovered     = "recovered"
+)
+
+// Event 服务状态变化事件
+type Event struct {
+	Time    int64  `json:"time"`
+	Type    string `json:"type"`
+	Service string `json:"service,omitempty"`
+	Pid     int    `json:"pid,omitempty"`
+	Code    *int   `json:"code,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+// NewEvent 创建事件，时间为当前时间
+func NewEvent(typ, service string, pid int, message string) *Event {
+	return &Event{
+		Time:    time.Now().Unix(),
+		Type:    typ,
+		Service: service,
+		Pid:     pid,
+		Message: message,
+	}
+}
+
+// WithCode 设置退出码
+func (e *Event) WithCode(code int) *Event {
+	e.Code = &code
+	return e
+}
+
+// String 单行的可读格式
+func (e *Event) String() string {
+	ss := strings.Builder{}
+	ss.WriteString(time.Unix(e.Time, 0).Format("2006-01-02 15:04:05"))
+	ss.WriteString("  " + e.Type)
+	if e.Service != "" {
+		ss.WriteString("  " + e.Service)
+	}
+	if e.Pid > 0 {
+		ss.WriteString("  pid=" + strconv.Itoa(e.Pid))
+	}
+	if e.Code != nil {
+		ss.WriteString("  code=" + strconv.Itoa(*e.Code))
+	}
+	if e.Message != "" {
+		ss.WriteString("  " + e.Message)
+	}
+	return ss.String()
+}
@@ -0,0 +1,66 @@
+package model
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+)
+
+// StreamSock 流协议的socket，每个请求和回复都是4字节大端长度+json的帧
+const StreamSock = "@ssdctld.stream"
+
+var StreamAddr = &net.UnixAddr{Name: StreamSock, Net: "unix"}
+
+// MaxFrameSize 帧内容的最大长度，避免按对方声明的长度分配过大的内存
+const MaxFrameSize = 4 << 20
+
+var errFrameTooLarge = errors.New("frame too large, max " + strconv.Itoa(MaxFrameSize) + " bytes")
+
+// 帧类型
+const (
+	FrameOutput   = "output"   // 命令输出，console数据或事件
+	FrameResponse = "response" // 结构化的结果，在对应的输出之后发送
+	FrameDone     = "done"     // 请求处理完成
+)
+
+// Frame 服务端发给客户端的帧，ID和请求的ToDo.ID对应
+type Frame struct {
+	ID       uint64    `json:"id"`
+	Kind     string    `json:"kind"`
+	Data     []byte    `json:"data,omitempty"`
+	Response *Response `json:"response,omitempty"`
+}
+
+// WriteFrame 写入一个帧
+func WriteFrame(w io.Writer, v any) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if len(b) > MaxFrameSize {
+		return errFrameTooLarge
+	}
+	b = append(binary.BigEndian.AppendUint32(make([]byte, 0, 4+len(b)), uint32(len(b))), b...)
+	_, err = w.Write(b)
+	return err
+}
+
+// ReadFrame 读取一个帧的内容
+func ReadFrame(r io.Reader) ([]byte, error) {
+	var h [4]byte
+	if _, err := io.ReadFull(r, h[:]); err != nil {
+		return nil, err
+	}
+	n := binary.BigEndian.Uint32(h[:])
+	if n > MaxFrameSize {
+		return nil, errFrameTooLarge
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
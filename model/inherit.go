@@ -0,0 +1,239 @@
+package model
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultsName cnf.d中所有服务共用的默认配置，以_开头的文件都是模板，只用于extends，不作为服务加载
+const DefaultsName = "_defaults"
+
+// IsTemplate 模板配置文件
+func IsTemplate(name string) bool {
+	return strings.HasPrefix(name, "_")
+}
+
+// configSet 一次读取的所有配置文件
+type configSet struct {
+	files map[string][]byte
+	nodes map[string]*yaml.Node
+}
+
+// node 解析后的配置，空文件为空的mapping
+func (cs *configSet) node(name string) (*yaml.Node, error) {
+	if n, ok := cs.nodes[name]; ok {
+		return n, nil
+	}
+	b, ok := cs.files[name]
+	if !ok {
+		return nil, errors.New("not found")
+	}
+	doc := &yaml.Node{}
+	if err := yaml.Unmarshal(b, doc); err != nil {
+		return nil, err
+	}
+	n := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+	if len(doc.Content) > 0 {
+		n = doc.Content[0]
+	}
+	cs.nodes[name] = n
+	return n, nil
+}
+
+// resolve 按extends依次合并，_defaults.yaml合并在继承链的最底层，seen用于发现循环继承
+func (cs *configSet) resolve(name string, seen []string) (*yaml.Node, error) {
+	if slices.Contains(seen, name) {
+		return nil, errors.New("extends loop: " + strings.Join(append(seen, name), " -> "))
+	}
+	n, err := cs.node(name)
+	if err != nil {
+		if len(seen) > 0 {
+			return nil, errors.New("extends " + name + ".yaml: " + err.Error())
+		}
+		return nil, err
+	}
+	parent := mappingValue(n, "extends")
+	if parent != "" {
+		base, err := cs.resolve(parent, append(seen, name))
+		if err != nil {
+			return nil, err
+		}
+		return mergeNode(base, n), nil
+	}
+	// 没有默认配置时也合并一次，处理key+的写法
+	base := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+	if _, ok := cs.files[DefaultsName]; ok && name != DefaultsName {
+		if base, err = cs.node(DefaultsName); err != nil {
+			return nil, errors.New(DefaultsName + ".yaml: " + err.Error())
+		}
+	}
+	return mergeNode(base, n), nil
+}
+
+// mergeNode 深度合并两个mapping，over中的值覆盖base，mapping递归合并，
+// 列表默认替换，key以+结尾时追加到base的列表之后，不修改参数中的节点
+func mergeNode(base, over *yaml.Node) *yaml.Node {
+	if base.Kind != yaml.MappingNode || over.Kind != yaml.MappingNode {
+		return over
+	}
+	out := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+	idx := make(map[string]int)
+	set := func(k, v *yaml.Node) {
+		name := strings.TrimSuffix(k.Value, "+")
+		if name != k.Value {
+			x := *k
+			x.Value = name
+			k = &x
+		}
+		if i, ok := idx[name]; ok {
+			out.Content[i+1] = v
+			return
+		}
+		idx[name] = len(out.Content)
+		out.Content = append(out.Content, k, v)
+	}
+	for i := 0; i+1 < len(base.Content); i += 2 {
+		set(base.Content[i], base.Content[i+1])
+	}
+	for i := 0; i+1 < len(over.Content); i += 2 {
+		k, v := over.Content[i], over.Content[i+1]
+		name, appendList := strings.CutSuffix(k.Value, "+")
+		if j, ok := idx[name]; ok {
+			bv := out.Content[j+1]
+			switch {
+			case appendList && bv.Kind == yaml.SequenceNode && v.Kind == yaml.SequenceNode:
+				v = &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq", Content: append(slices.Clone(bv.Content), v.Content...)}
+			case bv.Kind == yaml.MappingNode && v.Kind == yaml.MappingNode:
+				v = mergeNode(bv, v)
+			}
+		}
+		set(k, v)
+	}
+	return out
+}
+
+// mappingValue mapping中key对应的字符串值
+func mappingValue(n *yaml.Node, key string) string {
+	if n.Kind != yaml.MappingNode {
+		return ""
+	}
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		if n.Content[i].Value == key && n.Content[i+1].Kind == yaml.ScalarNode {
+			return n.Content[i+1].Value
+		}
+	}
+	return ""
+}
+
+// setMappingValue 设置映射中key的标量值，没有时添加到最后
+func setMappingValue(n *yaml.Node, key, value string) {
+	setMappingNode(n, key, &yaml.Node{Kind: yaml.ScalarNode, Value: value})
+}
+
+// setMappingNode 设置映射中key的值，key+改为key，保留行尾注释，没有时添加到最后
+func setMappingNode(n *yaml.Node, key string, value *yaml.Node) {
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		if k := n.Content[i]; k.Value == key || k.Value == key+"+" {
+			k.Value = key
+			old := n.Content[i+1]
+			if old.Style&yaml.FlowStyle != 0 && value.Kind != yaml.ScalarNode { // 保持原来的写法
+				value.Style |= yaml.FlowStyle
+			}
+			if value.Kind == yaml.ScalarNode || value.Style&yaml.FlowStyle != 0 {
+				value.LineComment = old.LineComment
+			} else if k.LineComment == "" { // 多行的值注释放在key后面
+				k.LineComment = old.LineComment
+			}
+			n.Content[i+1] = value
+			n.Content = append(n.Content[:i+2], deleteKey(n.Content[i+2:], key, key+"+")...)
+			return
+		}
+	}
+	n.Content = append(n.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: key}, value)
+}
+
+// delMappingKey 删除映射中的key和key+
+func delMappingKey(n *yaml.Node, key string) {
+	n.Content = deleteKey(n.Content, key, key+"+")
+}
+
+func deleteKey(content []*yaml.Node, keys ...string) []*yaml.Node {
+	out := content[:0]
+	for i := 0; i+1 < len(content); i += 2 {
+		if !slices.Contains(keys, content[i].Value) {
+			out = append(out, content[i], content[i+1])
+		}
+	}
+	return out
+}
+
+// LoadDir 读取目录中的所有配置，合并_defaults.yaml和extends后检查，返回每个文件的问题，
+// override中的内容代替同名的文件，用于检查还未保存到目录中的配置
+func LoadDir(dir string, override map[string][]byte) (map[string]*ServiceParams, []*ConfigError, error) {
+	fsd, err := os.ReadDir(dir)
+	if err != nil && !(os.IsNotExist(err) && len(override) > 0) {
+		return nil, nil, err
+	}
+	cs := &configSet{
+		files: make(map[string][]byte),
+		nodes: make(map[string]*yaml.Node),
+	}
+	errs := make([]*ConfigError, 0)
+	for _, fs := range fsd {
+		if fs.IsDir() || filepath.Ext(fs.Name()) != ".yaml" {
+			continue
+		}
+		b, err := os.ReadFile(filepath.Join(dir, fs.Name()))
+		if err != nil {
+			errs = append(errs, &ConfigError{File: fs.Name(), Errors: []string{err.Error()}, Skipped: true})
+			continue
+		}
+		cs.files[strings.TrimSuffix(fs.Name(), ".yaml")] = b
+	}
+	for k, v := range override {
+		cs.files[k] = v
+	}
+	names := make([]string, 0, len(cs.files))
+	for k := range cs.files {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	data := make(map[string]*ServiceParams)
+	for _, name := range names {
+		file := name + ".yaml"
+		own, err := cs.node(name)
+		if err != nil {
+			errs = append(errs, &ConfigError{File: file, Errors: []string{err.Error()}, Skipped: true})
+			continue
+		}
+		ss := unknownKeys(own, reflect.TypeFor[ServiceParams](), make([]string, 0))
+		if IsTemplate(name) {
+			if len(ss) > 0 {
+				errs = append(errs, &ConfigError{File: file, Errors: ss})
+			}
+			continue
+		}
+		n, err := cs.resolve(name, nil)
+		if err != nil {
+			errs = append(errs, &ConfigError{File: file, Errors: append(ss, err.Error()), Skipped: true})
+			continue
+		}
+		s := &ServiceParams{}
+		if err := n.Decode(s); err != nil {
+			errs = append(errs, &ConfigError{File: file, Errors: append(ss, err.Error()), Skipped: true})
+			continue
+		}
+		if ss = append(ss, CheckService(name, s, dir)...); len(ss) > 0 {
+			errs = append(errs, &ConfigError{File: file, Errors: ss})
+		}
+		data[name] = s
+	}
+	return data, errs, nil
+}
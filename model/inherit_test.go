@@ -0,0 +1,319 @@
+package model
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func parseNode(t *testing.T, s string) *yaml.Node {
+	t.Helper()
+	doc := &yaml.Node{}
+	if err := yaml.Unmarshal([]byte(s), doc); err != nil {
+		t.Fatal(err)
+	}
+	return doc.Content[0]
+}
+
+func decodeNode(t *testing.T, n *yaml.Node) map[string]any {
+	t.Helper()
+	m := make(map[string]any)
+	if err := n.Decode(&m); err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func TestMergeNode(t *testing.T) {
+	cases := []struct {
+		name       string
+		base, over string
+		want       map[string]any
+	}{
+		{
+			name: "override scalar and keep others",
+			base: "exec: /bin/a\ndir: /tmp",
+			over: "exec: /bin/b",
+			want: map[string]any{"exec": "/bin/b", "dir": "/tmp"},
+		},
+		{
+			name: "replace list",
+			base: "env: [A=1, B=2]",
+			over: "env: [C=3]",
+			want: map[string]any{"env": []any{"C=3"}},
+		},
+		{
+			name: "append list",
+			base: "env: [A=1]",
+			over: "env+: [B=2, C=3]",
+			want: map[string]any{"env": []any{"A=1", "B=2", "C=3"}},
+		},
+		{
+			name: "append without base",
+			base: "exec: /bin/a",
+			over: "env+: [B=2]",
+			want: map[string]any{"exec": "/bin/a", "env": []any{"B=2"}},
+		},
+		{
+			name: "merge maps deeply",
+			base: "limits: {nofile: 1024, nproc: 64}\nlog: {size: 10}",
+			over: "limits: {nproc: 128, core: 0}",
+			want: map[string]any{
+				"limits": map[string]any{"nofile": 1024, "nproc": 128, "core": 0},
+				"log":    map[string]any{"size": 10},
+			},
+		},
+		{
+			name: "scalar replaces map",
+			base: "limits: {nofile: 1024}",
+			over: "limits: null",
+			want: map[string]any{"limits": nil},
+		},
+	}
+	for _, c := range cases {
+		base, over := parseNode(t, c.base), parseNode(t, c.over)
+		before := decodeNode(t, base)
+		got := decodeNode(t, mergeNode(base, over))
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: got %v, want %v", c.name, got, c.want)
+		}
+		if !reflect.DeepEqual(decodeNode(t, base), before) {
+			t.Errorf("%s: base was modified", c.name)
+		}
+	}
+}
+
+func TestResolve(t *testing.T) {
+	files := map[string]string{
+		DefaultsName: "env: [D=1]\nstartsec: 5",
+		"_base":      "exec: /bin/sh\nenv+: [B=1]",
+		"app":        "extends: _base\nenv+: [A=1]\nstartsec: 3",
+		"plain":      "exec: /bin/sh",
+		"self":       "extends: self",
+		"loop1":      "extends: loop2",
+		"loop2":      "extends: loop1",
+		"orphan":     "extends: nope",
+		"broken":     "extends: _bad",
+		"_bad":       "exec: [",
+	}
+	cs := &configSet{files: make(map[string][]byte), nodes: make(map[string]*yaml.Node)}
+	for k, v := range files {
+		cs.files[k] = []byte(v)
+	}
+	n, err := cs.resolve("app", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := decodeNode(t, n)
+	want := map[string]any{"extends": "_base", "exec": "/bin/sh", "env": []any{"D=1", "B=1", "A=1"}, "startsec": 3}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("app: got %v, want %v", got, want)
+	}
+	n, err = cs.resolve("plain", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := decodeNode(t, n); got["startsec"] != 5 || !reflect.DeepEqual(got["env"], []any{"D=1"}) {
+		t.Errorf("plain: defaults not merged, got %v", got)
+	}
+
+	errCases := map[string]string{
+		"self":   "extends loop: self -> self",
+		"loop1":  "extends loop: loop1 -> loop2 -> loop1",
+		"orphan": "extends nope.yaml: not found",
+		"broken": "extends _bad.yaml: yaml:",
+	}
+	for name, want := range errCases {
+		_, err := cs.resolve(name, nil)
+		if err == nil || !strings.HasPrefix(err.Error(), want) {
+			t.Errorf("%s: got error %v, want %s", name, err, want)
+		}
+	}
+}
+
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for k, v := range files {
+		if err := os.WriteFile(filepath.Join(dir, k+".yaml"), []byte(v), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestLoadDir(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		DefaultsName: "env: [LOG=info]\nstartsec: 3",
+		"_java":      "exec: /bin/sh\nparams: [-c]\nunknown: 1",
+		"app":        "extends: _java\nparams+: [exit 0]\nenv+: [TZ=UTC]",
+		"loop1":      "extends: loop2\nexec: /bin/sh",
+		"loop2":      "extends: loop1\nexec: /bin/sh",
+		"typo":       "exec: /bin/sh\nenvv: [A=1]",
+	})
+	data, errs, err := LoadDir(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := make([]string, 0, len(data))
+	for k := range data {
+		names = append(names, k)
+	}
+	slices.Sort(names)
+	if !slices.Equal(names, []string{"app", "typo"}) {
+		t.Errorf("loaded %v, want [app typo]", names)
+	}
+	app := data["app"]
+	if app == nil {
+		t.Fatal("app not loaded")
+	}
+	if app.Exec != "/bin/sh" || !slices.Equal(app.Params, []string{"-c", "exit 0"}) ||
+		!slices.Equal(app.Env, []string{"LOG=info", "TZ=UTC"}) || app.StartSec != 3 || app.Extends != "_java" {
+		t.Errorf("app = %+v", app)
+	}
+	got := make(map[string]*ConfigError)
+	for _, e := range errs {
+		got[e.File] = e
+	}
+	if e := got["_java.yaml"]; e == nil || e.Skipped || len(e.Errors) != 1 || !strings.Contains(e.Errors[0], "unknown") {
+		t.Errorf("_java.yaml error = %+v", e)
+	}
+	if e := got["typo.yaml"]; e == nil || e.Skipped || !strings.Contains(strings.Join(e.Errors, ";"), "envv") {
+		t.Errorf("typo.yaml error = %+v", e)
+	}
+	for _, f := range []string{"loop1.yaml", "loop2.yaml"} {
+		if e := got[f]; e == nil || !e.Skipped || !strings.Contains(strings.Join(e.Errors, ";"), "extends loop") {
+			t.Errorf("%s error = %+v", f, e)
+		}
+	}
+	if len(errs) != 4 {
+		t.Errorf("got %d errors, want 4", len(errs))
+	}
+
+	// override代替目录中的文件
+	data, _, err = LoadDir(dir, map[string][]byte{"app": []byte("extends: _java\nstartsec: 7")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if app := data["app"]; app == nil || app.StartSec != 7 || !slices.Equal(app.Params, []string{"-c"}) {
+		t.Errorf("override app = %+v", app)
+	}
+}
+
+func TestSetEnablePatchesFile(t *testing.T) {
+	dir := t.TempDir()
+	src := "# app config\nextends: _base\nenv+:\n  - TZ=UTC # zone\nenable: false\n"
+	writeFiles(t, dir, map[string]string{"_base": "exec: /bin/sh", "app": src})
+	c := NewCnf(dir, t.TempDir())
+	c.FromFiles()
+	if err := c.SetEnable("app", true); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.SetLevel("app", 10); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(filepath.Join(dir, "app.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "# app config\nextends: _base\nenv+:\n  - TZ=UTC # zone\nenable: true\npriority: 10\n"
+	if string(b) != want {
+		t.Errorf("app.yaml:\n%s\nwant:\n%s", b, want)
+	}
+	data, errs, _ := LoadDir(dir, nil)
+	if len(errs) > 0 || !data["app"].Enable || data["app"].Priority != 10 || data["app"].Exec != "/bin/sh" {
+		t.Errorf("reload app = %+v, errors %v", data["app"], errs)
+	}
+}
+
+func TestCheckItemDependents(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"base":  "exec: /bin/sh\nenv: [A=1]",
+		"child": "extends: base\nenv+: [B=2]",
+		"other": "exec: /bin/sh\nbad: 1",
+	})
+	c := NewCnf(dir, t.TempDir())
+	c.FromFiles()
+	if svr, ce := c.CheckItem("base", []byte("exec: /bin/sh\nenv: [A=2]")); svr == nil || ce != nil {
+		t.Errorf("valid edit: %v", ce)
+	}
+	// other原有的问题不报告
+	svr, ce := c.CheckItem("base", []byte("exec: /bin/sh\nenv: [A=1]\nbad: 2"))
+	if svr != nil || ce == nil || ce.File != "base.yaml" || strings.Contains(strings.Join(ce.Errors, ";"), "other.yaml") {
+		t.Errorf("errors = %v", ce)
+	}
+	// 修改前就有的问题只作为警告
+	svr, ce = c.CheckItem("other", []byte("exec: /bin/sh\nenv: [A=1]\nbad: 1"))
+	if svr == nil || ce == nil || len(ce.Errors) != 1 || !strings.Contains(ce.Errors[0], "unknown key `bad`") {
+		t.Errorf("known error: svr = %v, warnings = %v", svr, ce)
+	}
+	// 无法加载时即使问题原来就有也拒绝
+	if svr, ce := c.CheckItem("other", []byte("exec: [")); svr != nil || ce == nil || !ce.Skipped {
+		t.Errorf("broken file: svr = %v, errors = %v", svr, ce)
+	}
+	// 继承了base的child因为循环继承无法加载
+	_, ce = c.CheckItem("base", []byte("extends: child\nexec: /bin/sh"))
+	s := ""
+	if ce != nil {
+		s = strings.Join(ce.Errors, ";")
+	}
+	if !strings.Contains(s, "extends loop: base -> child -> base") || !strings.Contains(s, "child.yaml: extends loop: child -> base -> child") {
+		t.Errorf("loop errors = %v", ce)
+	}
+}
+
+func TestUpdateContent(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"_base": "exec: /bin/sh\nenv: [A=1]\ntags: [web]",
+		"app":   "# app config\nextends: _base\nenv+: [B=2] # extra\nparams: [-c, exit 0]\n",
+		"plain": "exec: /bin/sh\ntags: [x]\nstartsec: 5\nnotify:\n  - a\n",
+	})
+	c := NewCnf(dir, t.TempDir())
+	c.FromFiles()
+	cases := []struct {
+		name   string
+		update func(s *ServiceParams)
+		want   string
+	}{
+		{
+			name:   "app",
+			update: func(s *ServiceParams) {},
+			want:   "# app config\nextends: _base\nenv+: [B=2] # extra\nparams: [-c, exit 0]\n",
+		},
+		{
+			name:   "app",
+			update: func(s *ServiceParams) { s.Env = append(s.Env, "C=3"); s.Tags = nil; s.Priority = 10 },
+			want:   "# app config\nextends: _base\nenv: [A=1, B=2, C=3] # extra\nparams: [-c, exit 0]\ntags: null\npriority: 10\n",
+		},
+		{
+			name:   "plain",
+			update: func(s *ServiceParams) { s.Tags = []string{}; s.StartSec = 7; s.Notify = append(s.Notify, "b") },
+			want:   "exec: /bin/sh\nstartsec: 7\nnotify:\n  - a\n  - b\n",
+		},
+	}
+	for _, x := range cases {
+		svr, _ := c.GetItem(x.name)
+		x.update(svr)
+		b, err := c.UpdateContent(x.name, svr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != x.want {
+			t.Errorf("%s:\n%s\nwant:\n%s", x.name, b, x.want)
+		}
+	}
+	// 写入后重新加载得到同样的配置
+	svr, _ := c.GetItem("app")
+	svr.Tags = nil
+	b, _ := c.UpdateContent("app", svr)
+	s, ce := c.CheckItem("app", b)
+	if s == nil || ce != nil || len(s.Tags) != 0 || !slices.Equal(s.Env, []string{"A=1", "B=2"}) {
+		t.Errorf("check updated app = %+v, errors %v", s, ce)
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"net"
 )
 
+// SvrSock 数据报协议，只为兼容旧版本客户端保留，新客户端使用StreamSock
 const (
 	SvrSock = "@ssdctld.sock"
 	CliSock = "@ssdctl_%d.sock"
@@ -19,10 +20,14 @@ var (
 )
 
 type ToDo struct {
+	ID     uint64   `json:"id,omitempty"` // 流协议中的请求序号，回复的帧使用相同的ID
 	Name   string   `json:"name"`
 	Exec   string   `json:"exec,omitempty"`
 	Params []string `json:"params,omitempty"`
 	Do     Jobs     `json:"do"`
+	Data   []byte   `json:"data,omitempty"`
+	Format string   `json:"format,omitempty"`
+	Tree   bool     `json:"tree,omitempty"`
 }
 
 func (td *ToDo) ToJSON() []byte {
@@ -45,17 +50,30 @@ func (td *ToDo) FromJSON(b []byte) error {
 }
 
 type ServiceParams struct {
-	name       string   `yaml:"-"`
-	Exec       string   `yaml:"exec"`
-	Dir        string   `yaml:"dir,omitempty"`
-	Params     []string `yaml:"params"`
-	Replace    []string `yaml:"replace,omitempty"`
-	Env        []string `yaml:"env,omitempty"`
-	Pid        int      `yaml:"-"`
-	StartSec   uint32   `yaml:"startsec"`
-	Priority   uint32   `yaml:"priority"`
-	Enable     bool     `yaml:"enable"`
-	ManualStop bool     `yaml:"-"`
+	name           string       `yaml:"-" json:"-"`
+	Extends        string       `yaml:"extends,omitempty" json:"extends,omitempty"` // 继承的服务或cnf.d中以_开头的模板
+	Exec           string       `yaml:"exec" json:"exec"`
+	Dir            string       `yaml:"dir,omitempty" json:"dir,omitempty"`
+	Params         []string     `yaml:"params" json:"params"`
+	Replace        []ReplaceVar `yaml:"replace,omitempty" json:"replace,omitempty"`
+	Env            []string     `yaml:"env,omitempty" json:"env,omitempty"`
+	EnvFiles       []string     `yaml:"env_files,omitempty" json:"env_files,omitempty"`
+	CleanEnv       bool         `yaml:"clean_env,omitempty" json:"clean_env,omitempty"`
+	Console        bool         `yaml:"console,omitempty" json:"console,omitempty"`
+	Notify         []string     `yaml:"notify,omitempty" json:"notify,omitempty"`
+	Tags           []string     `yaml:"tags,omitempty" json:"tags,omitempty"`
+	OnConfigChange string       `yaml:"on_config_change,omitempty" json:"on_config_change,omitempty"` // 配置文件变化后是否重启，restart或none(默认)
+	Pid            int          `yaml:"-" json:"-"`
+	StartSec       uint32       `yaml:"startsec" json:"startsec"`
+	Priority       uint32       `yaml:"priority" json:"priority"`
+	Enable         bool         `yaml:"enable" json:"enable"`
+	ManualStop     bool         `yaml:"-" json:"-"`
+	Restarts       uint32       `yaml:"-" json:"-"`
+	ExitCode       int          `yaml:"-" json:"-"`
+	ExitTime       int64        `yaml:"-" json:"-"`
+	Crashed        bool         `yaml:"-" json:"-"`
+	Fails          uint32       `yaml:"-" json:"-"`
+	Fatal          bool         `yaml:"-" json:"-"`
 }
 
 type Jobs byte
@@ -74,8 +92,47 @@ const (
 	JobList
 	JobUpate
 	JobSetLevel
+	JobAttach
+	JobInput
+	JobDetach
+	JobPs
+	JobEvents
+	JobAudit
+	JobReexec
+	JobEdit
 )
 
+var jobNames = map[Jobs]string{
+	JobShutdown: "shutdown",
+	JobEnd:      "end",
+	JobStart:    "start",
+	JobStop:     "stop",
+	JobRestart:  "restart",
+	JobStatus:   "status",
+	JobEnable:   "enable",
+	JobDisable:  "disable",
+	JobCreate:   "create",
+	JobRemove:   "remove",
+	JobList:     "list",
+	JobUpate:    "update",
+	JobSetLevel: "setlevel",
+	JobAttach:   "attach",
+	JobInput:    "input",
+	JobDetach:   "detach",
+	JobPs:       "ps",
+	JobEvents:   "events",
+	JobAudit:    "audit",
+	JobReexec:   "reexec",
+	JobEdit:     "edit",
+}
+
+func (j Jobs) String() string {
+	if s, ok := jobNames[j]; ok {
+		return s
+	}
+	return "unknown"
+}
+
 const (
 	NameAll        = "all"
 	NameDisable    = "disable"
@@ -92,4 +149,28 @@ const (
 	NameShutdown   = "shutdown"
 	NameStartLevel = "startlevel"
 	NameUpdate     = "update"
+	NameAttach     = "attach"
+	NamePs         = "ps"
+	NameEvents     = "events"
+	NameAudit      = "audit"
+	NameDaemon     = "daemon"
+	NameReexec     = "reexec"
+	NameCheck      = "check"
+	NameEdit       = "edit"
 )
+
+// IsReservedName 命令关键字不能用作服务名
+func IsReservedName(name string) bool {
+	switch name {
+	case NameAll, NameDisable, NameEnable, NameStatus, NameStart, NameStop,
+		NameStopped, NameRestart, NameRemove, NameCreate, NameList, NameRunning:
+		return true
+	}
+	return false
+}
+
+// FormatJSON 输出json格式
+const FormatJSON = "json"
+
+// DetachKey ctrl+]，attach时断开连接
+const DetachKey = 0x1d
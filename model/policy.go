@@ -0,0 +1,108 @@
+package model
+
+import (
+	"os"
+	"path"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// 权限
+const (
+	PermRead    = "read"    // status, list, ps, events
+	PermControl = "control" // start, stop, restart, enable, disable
+	PermConsole = "console" // attach
+	PermConfig  = "config"  // create, remove, update, setlevel, edit
+	PermAdmin   = "admin"   // audit, shutdown, reexec，包含所有权限
+)
+
+// Policy 控制命令的访问策略，root和ssdctld自身的用户不受限制
+type Policy struct {
+	Rules []*PolicyRule `yaml:"rules"`
+}
+
+// PolicyRule 匹配users或groups中任意一项的调用方获得allow中的权限
+type PolicyRule struct {
+	Users    []string `yaml:"users,omitempty"`    // 用户名或uid
+	Groups   []string `yaml:"groups,omitempty"`   // 组名或gid，包括附加组
+	Allow    []string `yaml:"allow"`              // read, control, console, config, admin
+	Services []string `yaml:"services,omitempty"` // 服务名、通配符(如`web-*`)或`tag:xxx`，为空或`*`时所有服务，read权限不受限制
+}
+
+// Identity 调用方的用户和组，名称和id都会用于匹配
+type Identity struct {
+	Uid    int
+	Users  []string
+	Groups []string
+}
+
+// LoadPolicy 读取策略文件，文件不存在时返回nil，表示不做限制，
+// 无法解析时同时返回错误和空策略，只允许root和ssdctld自身的用户
+func LoadPolicy(path string) (*Policy, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	p := &Policy{}
+	if err := yaml.Unmarshal(b, p); err != nil {
+		return &Policy{}, err
+	}
+	return p, nil
+}
+
+// Allow 判断是否有权限，service为空表示不针对单个服务的操作(如all)，此时要求规则不限制服务
+func (p *Policy) Allow(id *Identity, perm, service string, tags []string) bool {
+	if id.Uid == 0 || id.Uid == os.Geteuid() {
+		return true
+	}
+	for _, r := range p.Rules {
+		if !r.match(id) {
+			continue
+		}
+		if !slices.Contains(r.Allow, perm) && !slices.Contains(r.Allow, PermAdmin) {
+			continue
+		}
+		if perm == PermRead || r.allService(service, tags) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *PolicyRule) match(id *Identity) bool {
+	for _, u := range r.Users {
+		if slices.Contains(id.Users, u) {
+			return true
+		}
+	}
+	for _, g := range r.Groups {
+		if slices.Contains(id.Groups, g) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *PolicyRule) allService(service string, tags []string) bool {
+	if len(r.Services) == 0 || slices.Contains(r.Services, "*") {
+		return true
+	}
+	if service == "" {
+		return false
+	}
+	for _, s := range r.Services {
+		if t, ok := strings.CutPrefix(s, "tag:"); ok {
+			if slices.Contains(tags, t) {
+				return true
+			}
+		} else if ok, _ := path.Match(s, service); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,82 @@
+package model
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPolicyAllow(t *testing.T) {
+	dir := t.TempDir()
+	good := filepath.Join(dir, "policy.yaml")
+	if err := os.WriteFile(good, []byte(`rules:
+  - users: [alice, "1001"]
+    allow: [read, control]
+    services: ["web-*", "tag:db"]
+  - groups: [ops, "2000"]
+    allow: [config]
+    services: [api]
+  - users: [carol]
+    allow: [admin]
+`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	bad := filepath.Join(dir, "bad.yaml")
+	if err := os.WriteFile(bad, []byte("rules: [\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	p, err := LoadPolicy(good)
+	if err != nil {
+		t.Fatal(err)
+	}
+	broken, err := LoadPolicy(bad)
+	if err == nil || broken == nil {
+		t.Fatalf("LoadPolicy(bad) = %v, %v, want an empty policy and an error", broken, err)
+	}
+	if p, err := LoadPolicy(filepath.Join(dir, "none.yaml")); p != nil || err != nil {
+		t.Errorf("LoadPolicy(missing) = %v, %v, want nil", p, err)
+	}
+
+	alice := &Identity{Uid: 1000, Users: []string{"1000", "alice"}, Groups: []string{"1000"}}
+	uid1001 := &Identity{Uid: 1001, Users: []string{"1001"}, Groups: []string{"1001"}}
+	bob := &Identity{Uid: 1002, Users: []string{"1002", "bob"}, Groups: []string{"1002", "ops"}}
+	gid := &Identity{Uid: 1003, Users: []string{"1003"}, Groups: []string{"2000"}}
+	carol := &Identity{Uid: 1004, Users: []string{"1004", "carol"}, Groups: []string{"1004"}}
+	nobody := &Identity{Uid: 65534, Users: []string{"65534", "nobody"}, Groups: []string{"65534"}}
+	root := &Identity{Uid: 0, Users: []string{"0", "root"}, Groups: []string{"0"}}
+	self := &Identity{Uid: os.Geteuid(), Users: []string{"self"}}
+	cases := []struct {
+		name    string
+		p       *Policy
+		id      *Identity
+		perm    string
+		service string
+		tags    []string
+		want    bool
+	}{
+		{name: "user name and glob", p: p, id: alice, perm: PermControl, service: "web-1", want: true},
+		{name: "glob mismatch", p: p, id: alice, perm: PermControl, service: "api"},
+		{name: "tag", p: p, id: alice, perm: PermControl, service: "pg", tags: []string{"db"}, want: true},
+		{name: "all services needs unrestricted rule", p: p, id: alice, perm: PermControl},
+		{name: "uid", p: p, id: uid1001, perm: PermControl, service: "web-2", want: true},
+		{name: "read not limited by services", p: p, id: alice, perm: PermRead, service: "api", want: true},
+		{name: "control does not include config", p: p, id: alice, perm: PermConfig, service: "web-1"},
+		{name: "group name", p: p, id: bob, perm: PermConfig, service: "api", want: true},
+		{name: "gid", p: p, id: gid, perm: PermConfig, service: "api", want: true},
+		{name: "group other service", p: p, id: bob, perm: PermConfig, service: "web-1"},
+		{name: "config does not include control", p: p, id: bob, perm: PermControl, service: "api"},
+		{name: "admin includes control", p: p, id: carol, perm: PermControl, service: "x", want: true},
+		{name: "admin includes console", p: p, id: carol, perm: PermConsole, service: "x", want: true},
+		{name: "admin on all services", p: p, id: carol, perm: PermAdmin, want: true},
+		{name: "no rule", p: p, id: nobody, perm: PermRead},
+		{name: "root", p: p, id: root, perm: PermAdmin, want: true},
+		{name: "broken denies user", p: broken, id: carol, perm: PermRead},
+		{name: "broken allows root", p: broken, id: root, perm: PermAdmin, want: true},
+		{name: "broken allows daemon user", p: broken, id: self, perm: PermConfig, service: "api", want: true},
+	}
+	for _, c := range cases {
+		if got := c.p.Allow(c.id, c.perm, c.service, c.tags); got != c.want {
+			t.Errorf("%s: Allow(%v, %s, %q) = %v, want %v", c.name, c.id.Users, c.perm, c.service, got, c.want)
+		}
+	}
+}
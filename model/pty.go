@@ -0,0 +1,84 @@
+package model
+
+import (
+	"os"
+	"strconv"
+	"syscall"
+	"unsafe"
+)
+
+type winsize struct {
+	Row    uint16
+	Col    uint16
+	Xpixel uint16
+	Ypixel uint16
+}
+
+func ioctl(fd, req, arg uintptr) error {
+	_, _, e := syscall.Syscall(syscall.SYS_IOCTL, fd, req, arg)
+	if e != 0 {
+		return e
+	}
+	return nil
+}
+
+// OpenPty only for linux, 打开一对伪终端
+func OpenPty() (*os.File, *os.File, error) {
+	master, err := os.OpenFile("/dev/ptmx", os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	var unlock int32
+	if err := ioctl(master.Fd(), syscall.TIOCSPTLCK, uintptr(unsafe.Pointer(&unlock))); err != nil {
+		master.Close()
+		return nil, nil, err
+	}
+	var n uint32
+	if err := ioctl(master.Fd(), syscall.TIOCGPTN, uintptr(unsafe.Pointer(&n))); err != nil {
+		master.Close()
+		return nil, nil, err
+	}
+	slave, err := os.OpenFile("/dev/pts/"+strconv.Itoa(int(n)), os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		master.Close()
+		return nil, nil, err
+	}
+	return master, slave, nil
+}
+
+// SetWinsize 设置终端窗口大小
+func SetWinsize(fd uintptr, rows, cols uint16) error {
+	ws := &winsize{Row: rows, Col: cols}
+	return ioctl(fd, syscall.TIOCSWINSZ, uintptr(unsafe.Pointer(ws)))
+}
+
+// GetWinsize 读取终端窗口大小
+func GetWinsize(fd uintptr) (uint16, uint16, error) {
+	ws := &winsize{}
+	if err := ioctl(fd, syscall.TIOCGWINSZ, uintptr(unsafe.Pointer(ws))); err != nil {
+		return 0, 0, err
+	}
+	return ws.Row, ws.Col, nil
+}
+
+// MakeRaw 将终端设置为raw模式，返回恢复函数
+func MakeRaw(fd uintptr) (func(), error) {
+	var old syscall.Termios
+	if err := ioctl(fd, syscall.TCGETS, uintptr(unsafe.Pointer(&old))); err != nil {
+		return nil, err
+	}
+	raw := old
+	raw.Iflag &^= syscall.IGNBRK | syscall.BRKINT | syscall.PARMRK | syscall.ISTRIP | syscall.INLCR | syscall.IGNCR | syscall.ICRNL | syscall.IXON
+	raw.Oflag &^= syscall.OPOST
+	raw.Lflag &^= syscall.ECHO | syscall.ECHONL | syscall.ICANON | syscall.ISIG | syscall.IEXTEN
+	raw.Cflag &^= syscall.CSIZE | syscall.PARENB
+	raw.Cflag |= syscall.CS8
+	raw.Cc[syscall.VMIN] = 1
+	raw.Cc[syscall.VTIME] = 0
+	if err := ioctl(fd, syscall.TCSETS, uintptr(unsafe.Pointer(&raw))); err != nil {
+		return nil, err
+	}
+	return func() {
+		_ = ioctl(fd, syscall.TCSETS, uintptr(unsafe.Pointer(&old)))
+	}, nil
+}
@@ -0,0 +1,142 @@
+package model
+
+import (
+	"errors"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ReplaceVar 参数替换变量，兼容旧的`$key=cmd args`写法，也可以写成:
+//
+//   - key: $pubip
+//     cmd: curl -s "4.ipw.cn"
+//     timeout: 5       // 命令超时秒数，默认10
+//     default: 0.0.0.0 // 命令失败时使用的值，不设置时启动报错
+//     cache: 600       // 结果缓存秒数，0不缓存
+type ReplaceVar struct {
+	Key     string  `yaml:"key" json:"key"`
+	Cmd     string  `yaml:"cmd" json:"cmd"`
+	Default *string `yaml:"default,omitempty" json:"default,omitempty"`
+	Timeout uint32  `yaml:"timeout,omitempty" json:"timeout,omitempty"`
+	Cache   uint32  `yaml:"cache,omitempty" json:"cache,omitempty"`
+}
+
+type replaceVar ReplaceVar
+
+func (rv *ReplaceVar) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		// 只按第一个'='拆分，命令里的'='保持原样
+		k, c, _ := strings.Cut(value.Value, "=")
+		*rv = ReplaceVar{
+			Key: strings.TrimSpace(k),
+			Cmd: strings.TrimSpace(c),
+		}
+		return nil
+	}
+	x := replaceVar{}
+	if err := value.Decode(&x); err != nil {
+		return err
+	}
+	*rv = ReplaceVar(x)
+	return nil
+}
+
+func (rv ReplaceVar) MarshalYAML() (any, error) {
+	if rv.Default == nil && rv.Timeout == 0 && rv.Cache == 0 {
+		return rv.Key + "=" + rv.Cmd, nil
+	}
+	return replaceVar(rv), nil
+}
+
+// Check 检查变量格式
+func (rv *ReplaceVar) Check() error {
+	if !strings.HasPrefix(rv.Key, "$") || len(rv.Key) < 2 {
+		return errors.New("replace key `" + rv.Key + "` must start with '$'")
+	}
+	if rv.Cmd == "" {
+		return errors.New("replace `" + rv.Key + "` has no command")
+	}
+	return nil
+}
+
+// SplitArgs 按shell规则拆分命令行，支持单引号、双引号和反斜杠转义
+func SplitArgs(s string) ([]string, error) {
+	args := make([]string, 0)
+	cur := strings.Builder{}
+	inArg := false
+	var quote byte
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote == '\'':
+			if c == '\'' {
+				quote = 0
+			} else {
+				cur.WriteByte(c)
+			}
+		case quote == '"':
+			switch c {
+			case '"':
+				quote = 0
+			case '\\':
+				if i+1 < len(s) && strings.IndexByte("\"\\$`", s[i+1]) >= 0 {
+					i++
+				}
+				cur.WriteByte(s[i])
+			default:
+				cur.WriteByte(c)
+			}
+		case c == '\'' || c == '"':
+			quote = c
+			inArg = true
+		case c == '\\':
+			if i+1 >= len(s) {
+				return nil, errors.New("trailing backslash in `" + s + "`")
+			}
+			i++
+			cur.WriteByte(s[i])
+			inArg = true
+		case c == ' ' || c == '\t' || c == '\n':
+			if inArg {
+				args = append(args, cur.String())
+				cur.Reset()
+				inArg = false
+			}
+		default:
+			cur.WriteByte(c)
+			inArg = true
+		}
+	}
+	if quote != 0 {
+		return nil, errors.New("unterminated quote in `" + s + "`")
+	}
+	if inArg {
+		args = append(args, cur.String())
+	}
+	return args, nil
+}
+
+// ExpandEnv 替换字符串中的`${NAME}`，`$name`形式留给replace处理
+func ExpandEnv(s string, lookup func(string) (string, bool)) string {
+	if !strings.Contains(s, "${") {
+		return s
+	}
+	b := strings.Builder{}
+	for {
+		i := strings.Index(s, "${")
+		if i < 0 {
+			break
+		}
+		j := strings.IndexByte(s[i:], '}')
+		if j < 0 {
+			break
+		}
+		b.WriteString(s[:i])
+		v, _ := lookup(s[i+2 : i+j])
+		b.WriteString(v)
+		s = s[i+j+1:]
+	}
+	b.WriteString(s)
+	return b.String()
+}
@@ -0,0 +1,30 @@
+package model
+
+import "encoding/json"
+
+// 结果码，和LSB init脚本的退出码一致
+const (
+	CodeOK         = 0
+	CodeFailed     = 1 // 一般错误
+	CodeInvalid    = 2 // 参数错误或不支持的命令
+	CodeDenied     = 4 // 权限不足
+	CodeNotFound   = 5 // 服务不存在
+	CodeNotRunning = 7 // 服务未运行
+)
+
+// status的结果码，0为运行中
+const (
+	StatusStopped = 3
+	StatusUnknown = 4
+)
+
+// Response 一个服务的操作结果，data的内容由action决定:
+// status为ServiceStatus，list为ServiceInfo，audit为AuditRecord
+type Response struct {
+	Service string          `json:"service,omitempty"`
+	Action  string          `json:"action"`
+	OK      bool            `json:"ok"`
+	Code    int             `json:"code"`
+	Message string          `json:"message,omitempty"`
+	Data    json.RawMessage `json:"data,omitempty"`
+}
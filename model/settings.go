@@ -0,0 +1,62 @@
+package model
+
+import (
+	"os"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Settings ssdctld自身的设置
+type Settings struct {
+	Metrics    string              `yaml:"metrics,omitempty"`     // prometheus metrics监听地址，如 127.0.0.1:9120，为空不启用
+	API        string              `yaml:"api,omitempty"`         // http/json接口监听地址，`unix:/path/to.sock`或本机tcp地址，为空不启用
+	APIToken   string              `yaml:"api_token,omitempty"`   // 接口监听tcp时必须设置，使用`Authorization: Bearer <token>`
+	Dashboard  *DashboardSettings  `yaml:"dashboard,omitempty"`   // web管理页面
+	FatalAfter uint32              `yaml:"fatal_after,omitempty"` // keepalive连续启动失败多少次后不再重启(FATAL)，0不限制
+	Notifiers  []*NotifierSettings `yaml:"notifiers,omitempty"`   // 异常通知，服务通过notify选择使用哪些
+	Reload     *ReloadSettings     `yaml:"reload,omitempty"`      // 重新加载cnf.d时的处理
+}
+
+// ReloadSettings 重新加载cnf.d(update或监视到文件变化)时的处理，
+// 配置变化的服务是否重启由服务的on_config_change决定
+type ReloadSettings struct {
+	Watch       bool   `yaml:"watch,omitempty"`        // 监视cnf.d，文件变化后自动重新加载
+	Debounce    uint32 `yaml:"debounce,omitempty"`     // 最后一次变化后等待的秒数，默认2
+	StartNew    bool   `yaml:"start_new,omitempty"`    // 启动新增的enable服务
+	StopRemoved bool   `yaml:"stop_removed,omitempty"` // 停止配置文件已删除的服务
+}
+
+// NotifierSettings 通知方式，webhook和command二选一
+type NotifierSettings struct {
+	Name    string            `yaml:"name"`
+	Webhook string            `yaml:"webhook,omitempty"` // POST json
+	Headers map[string]string `yaml:"headers,omitempty"` // webhook附加的请求头
+	Command string            `yaml:"command,omitempty"` // 本地命令，json从stdin传入
+	Events  []string          `yaml:"events,omitempty"`  // crash, restart, fatal, recovery，为空时全部
+	Rate    uint32            `yaml:"rate,omitempty"`    // 每分钟最多发送次数，默认10
+	Retry   uint32            `yaml:"retry,omitempty"`   // 失败重试次数，默认3
+}
+
+// DashboardSettings web管理页面设置，token和user/password至少设置一种
+type DashboardSettings struct {
+	Listen   string `yaml:"listen"`
+	Token    string `yaml:"token,omitempty"`
+	User     string `yaml:"user,omitempty"`
+	Password string `yaml:"password,omitempty"`
+}
+
+// LoadSettings 读取设置文件，文件不存在时返回默认设置
+func LoadSettings(path string) (*Settings, error) {
+	st := &Settings{}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return st, nil
+		}
+		return st, err
+	}
+	if err := yaml.Unmarshal(b, st); err != nil {
+		return st, err
+	}
+	return st, nil
+}
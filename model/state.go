@@ -0,0 +1,96 @@
+package model
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+)
+
+// ServiceState 需要在ssdctld重启后保留的运行时状态，pid仍然记录在pid.d中
+type ServiceState struct {
+	ManualStop bool   `json:"manual_stop,omitempty"`
+	Restarts   uint32 `json:"restarts,omitempty"`
+	ExitCode   int    `json:"exit_code,omitempty"`
+	ExitTime   int64  `json:"exit_time,omitempty"`
+	Crashed    bool   `json:"crashed,omitempty"`
+	Fails      uint32 `json:"fails,omitempty"`
+	Fatal      bool   `json:"fatal,omitempty"`
+}
+
+// copyRuntime 复制运行时状态
+func copyRuntime(dst, src *ServiceParams) {
+	dst.Pid, dst.ManualStop, dst.Restarts, dst.ExitCode, dst.ExitTime = src.Pid, src.ManualStop, src.Restarts, src.ExitCode, src.ExitTime
+	dst.Crashed, dst.Fails, dst.Fatal = src.Crashed, src.Fails, src.Fatal
+}
+
+// UseState 读取状态文件并应用到已加载的服务，之后运行时状态变化时都会写入该文件
+func (c *Config) UseState(path string) error {
+	c.locker.Lock()
+	defer c.locker.Unlock()
+	c.statefile = path
+	b, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	st := make(map[string]*ServiceState)
+	if err := json.Unmarshal(b, &st); err != nil {
+		return err
+	}
+	for k, v := range st {
+		s, ok := c.data[k]
+		if !ok {
+			continue
+		}
+		s.ManualStop, s.Restarts, s.ExitCode, s.ExitTime = v.ManualStop, v.Restarts, v.ExitCode, v.ExitTime
+		s.Crashed, s.Fails, s.Fatal = v.Crashed, v.Fails, v.Fatal
+	}
+	return nil
+}
+
+// SaveState 立即写入状态文件
+func (c *Config) SaveState() error {
+	c.locker.RLock()
+	defer c.locker.RUnlock()
+	return c.saveState()
+}
+
+// saveState 写入状态文件，先写临时文件再rename，调用时需持有锁
+func (c *Config) saveState() error {
+	if c.statefile == "" {
+		return nil
+	}
+	st := make(map[string]*ServiceState, len(c.data))
+	for k, s := range c.data {
+		st[k] = &ServiceState{
+			ManualStop: s.ManualStop,
+			Restarts:   s.Restarts,
+			ExitCode:   s.ExitCode,
+			ExitTime:   s.ExitTime,
+			Crashed:    s.Crashed,
+			Fails:      s.Fails,
+			Fatal:      s.Fatal,
+		}
+	}
+	b, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(c.statefile), filepath.Base(c.statefile)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err = f.Write(b); err == nil {
+		err = f.Sync()
+	}
+	if e := f.Close(); err == nil {
+		err = e
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), c.statefile)
+}
@@ -1,6 +1,7 @@
 package model
 
 import (
+	"errors"
 	"os"
 	"path/filepath"
 	"strconv"
@@ -64,3 +65,79 @@ func QueryProcess(name string) []*ProcessInfo {
 	}
 	return pi
 }
+
+// ProcStat /proc/[pid]/stat 中用到的字段
+type ProcStat struct {
+	Comm      string
+	State     byte
+	Pid       int
+	PPid      int
+	Pgid      int
+	Sid       int
+	Threads   int
+	Utime     uint64 // clock ticks
+	Stime     uint64 // clock ticks
+	StartTime uint64 // 系统启动后的clock ticks
+	RSS       uint64 // pages
+}
+
+// ReadProcStat only for linux
+func ReadProcStat(pid int) (*ProcStat, error) {
+	b, err := os.ReadFile("/proc/" + strconv.Itoa(pid) + "/stat")
+	if err != nil {
+		return nil, err
+	}
+	return parseProcStat(pid, string(b))
+}
+
+// ScanProcs only for linux, 遍历一次/proc，返回所有进程的stat
+func ScanProcs() map[int]*ProcStat {
+	ps := make(map[int]*ProcStat)
+	procs, err := os.ReadDir("/proc")
+	if err != nil {
+		return ps
+	}
+	for _, proc := range procs {
+		if !proc.IsDir() {
+			continue
+		}
+		pid, _ := strconv.Atoi(proc.Name())
+		if pid == 0 {
+			continue
+		}
+		st, err := ReadProcStat(pid)
+		if err != nil {
+			continue
+		}
+		ps[pid] = st
+	}
+	return ps
+}
+
+func parseProcStat(pid int, s string) (*ProcStat, error) {
+	// comm 可能包含空格和括号，以最后一个')'为界
+	l := strings.IndexByte(s, '(')
+	r := strings.LastIndexByte(s, ')')
+	if l < 0 || r < l || r+2 >= len(s) {
+		return nil, errors.New("malformed stat of pid " + strconv.Itoa(pid))
+	}
+	ff := strings.Fields(s[r+2:])
+	if len(ff) < 22 {
+		return nil, errors.New("malformed stat of pid " + strconv.Itoa(pid))
+	}
+	st := &ProcStat{
+		Comm:  s[l+1 : r],
+		State: ff[0][0],
+		Pid:   pid,
+	}
+	st.PPid, _ = strconv.Atoi(ff[1])
+	st.Pgid, _ = strconv.Atoi(ff[2])
+	st.Sid, _ = strconv.Atoi(ff[3])
+	// 字段序号见 man 5 proc，ff[0]是第3个字段state
+	st.Utime, _ = strconv.ParseUint(ff[11], 10, 64)
+	st.Stime, _ = strconv.ParseUint(ff[12], 10, 64)
+	st.Threads, _ = strconv.Atoi(ff[17])
+	st.StartTime, _ = strconv.ParseUint(ff[19], 10, 64)
+	st.RSS, _ = strconv.ParseUint(ff[21], 10, 64)
+	return st, nil
+}
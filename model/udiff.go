@@ -0,0 +1,116 @@
+package model
+
+import (
+	"strconv"
+	"strings"
+)
+
+// 差异前后保留的相同行数
+const diffContext = 3
+
+type diffLine struct {
+	op   byte // ' ', '-', '+'
+	text string
+}
+
+// UnifiedDiff 按行比较a和b，返回unified格式的差异，相同时返回空字符串
+func UnifiedDiff(aName, bName, a, b string) string {
+	if a == b {
+		return ""
+	}
+	ls := diffLines(splitLines(a), splitLines(b))
+	// 每行之前a和b已经出现的行数，用于计算hunk的起始行
+	ai, bi := make([]int, len(ls)+1), make([]int, len(ls)+1)
+	for i, l := range ls {
+		ai[i+1], bi[i+1] = ai[i], bi[i]
+		if l.op != '+' {
+			ai[i+1]++
+		}
+		if l.op != '-' {
+			bi[i+1]++
+		}
+	}
+	out := strings.Builder{}
+	out.WriteString("--- " + aName + "\n+++ " + bName + "\n")
+	for i := 0; i < len(ls); {
+		for i < len(ls) && ls[i].op == ' ' {
+			i++
+		}
+		if i == len(ls) {
+			break
+		}
+		// 中间相同的行不超过2*diffContext的变化合并为一个hunk
+		start, end := max(i-diffContext, 0), i
+		for j := i; j < len(ls) && j-end-1 <= 2*diffContext; j++ {
+			if ls[j].op != ' ' {
+				end = j
+			}
+		}
+		stop := min(end+diffContext+1, len(ls))
+		out.WriteString("@@ -" + hunkRange(ai[start], ai[stop]-ai[start]) + " +" + hunkRange(bi[start], bi[stop]-bi[start]) + " @@\n")
+		for _, l := range ls[start:stop] {
+			out.WriteByte(l.op)
+			out.WriteString(l.text + "\n")
+		}
+		i = stop
+	}
+	return out.String()
+}
+
+func hunkRange(start, n int) string {
+	if n == 0 {
+		return strconv.Itoa(start) + ",0"
+	}
+	if n == 1 {
+		return strconv.Itoa(start + 1)
+	}
+	return strconv.Itoa(start+1) + "," + strconv.Itoa(n)
+}
+
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+}
+
+// diffLines 最长公共子序列，配置文件很小，直接使用O(n*m)的动态规划
+func diffLines(a, b []string) []diffLine {
+	n, m := len(a), len(b)
+	lcs := make([][]int, n+1)
+	for i := range lcs {
+		lcs[i] = make([]int, m+1)
+	}
+	for i := n - 1; i >= 0; i-- {
+		for j := m - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+	ls := make([]diffLine, 0, n+m)
+	i, j := 0, 0
+	for i < n && j < m {
+		switch {
+		case a[i] == b[j]:
+			ls = append(ls, diffLine{' ', a[i]})
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			ls = append(ls, diffLine{'-', a[i]})
+			i++
+		default:
+			ls = append(ls, diffLine{'+', b[j]})
+			j++
+		}
+	}
+	for ; i < n; i++ {
+		ls = append(ls, diffLine{'-', a[i]})
+	}
+	for ; j < m; j++ {
+		ls = append(ls, diffLine{'+', b[j]})
+	}
+	return ls
+}
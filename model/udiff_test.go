@@ -0,0 +1,86 @@
+package model
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// numLines 1到n的行，repl中的行号替换为指定内容
+func numLines(n int, repl map[int]string) string {
+	b := strings.Builder{}
+	for i := 1; i <= n; i++ {
+		s, ok := repl[i]
+		if !ok {
+			s = strconv.Itoa(i)
+		}
+		b.WriteString(s + "\n")
+	}
+	return b.String()
+}
+
+func TestUnifiedDiff(t *testing.T) {
+	cases := []struct {
+		name string
+		a, b string
+		want string
+	}{
+		{
+			name: "same",
+			a:    "a\nb\n",
+			b:    "a\nb\n",
+			want: "",
+		},
+		{
+			name: "empty old",
+			a:    "",
+			b:    "x\ny\n",
+			want: "@@ -0,0 +1,2 @@\n+x\n+y\n",
+		},
+		{
+			name: "empty new",
+			a:    "x\ny\n",
+			b:    "",
+			want: "@@ -1,2 +0,0 @@\n-x\n-y\n",
+		},
+		{
+			name: "single changed line",
+			a:    "a\nb\nc\n",
+			b:    "a\nB\nc\n",
+			want: "@@ -1,3 +1,3 @@\n a\n-b\n+B\n c\n",
+		},
+		{
+			name: "single line file",
+			a:    "a\n",
+			b:    "b\n",
+			want: "@@ -1 +1 @@\n-a\n+b\n",
+		},
+		{
+			name: "merged hunk",
+			a:    numLines(14, nil),
+			b:    numLines(14, map[int]string{1: "a", 8: "h"}),
+			want: "@@ -1,11 +1,11 @@\n-1\n+a\n 2\n 3\n 4\n 5\n 6\n 7\n-8\n+h\n 9\n 10\n 11\n",
+		},
+		{
+			name: "separate hunks",
+			a:    numLines(14, nil),
+			b:    numLines(14, map[int]string{1: "a", 9: "i"}),
+			want: "@@ -1,4 +1,4 @@\n-1\n+a\n 2\n 3\n 4\n@@ -6,7 +6,7 @@\n 6\n 7\n 8\n-9\n+i\n 10\n 11\n 12\n",
+		},
+		{
+			name: "insert and delete",
+			a:    numLines(12, nil),
+			b:    strings.Replace(numLines(12, map[int]string{1: "0\n1"}), "12\n", "", 1),
+			want: "@@ -1,3 +1,4 @@\n+0\n 1\n 2\n 3\n@@ -9,4 +10,3 @@\n 9\n 10\n 11\n-12\n",
+		},
+	}
+	for _, c := range cases {
+		got := UnifiedDiff("a", "b", c.a, c.b)
+		if c.want != "" {
+			c.want = "--- a\n+++ b\n" + c.want
+		}
+		if got != c.want {
+			t.Errorf("%s:\ngot:\n%s\nwant:\n%s", c.name, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,208 @@
+package model
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ClockTicks USER_HZ，linux下固定为100
+const ClockTicks = 100
+
+// ResourceUsage 服务进程组的资源占用
+type ResourceUsage struct {
+	Pid        int     `json:"pid"`
+	Procs      int     `json:"procs"`
+	State      string  `json:"state"`
+	CPUPercent float64 `json:"cpu_percent"`
+	CPUSeconds float64 `json:"cpu_seconds"`
+	RSS        uint64  `json:"rss_bytes"`
+	Threads    int     `json:"threads"`
+	FDs        int     `json:"fds"`
+	StartTime  int64   `json:"start_time"`
+	Uptime     int64   `json:"uptime_seconds"`
+}
+
+// ServiceStatus status命令的结构化输出
+type ServiceStatus struct {
+	Name       string         `json:"name"`
+	Running    bool           `json:"running"`
+	Enable     bool           `json:"enable"`
+	Priority   uint32         `json:"priority"`
+	ManualStop bool           `json:"manual_stop"`
+	Restarts   uint32         `json:"restarts"`
+	Fatal      bool           `json:"fatal,omitempty"`
+	ExitCode   *int           `json:"last_exit_code,omitempty"`
+	ExitTime   int64          `json:"last_exit_time,omitempty"`
+	Usage      *ResourceUsage `json:"usage,omitempty"`
+	Tree       *ProcNode      `json:"tree,omitempty"`
+}
+
+// ServiceInfo list命令的结构化输出，配置和状态
+type ServiceInfo struct {
+	Name   string         `json:"name"`
+	Config *ServiceParams `json:"config"`
+	Status *ServiceStatus `json:"status"`
+}
+
+// State 运行状态：running, fatal, stopped(手动停止), exited(启用但未运行), disabled
+func (st *ServiceStatus) State() string {
+	switch {
+	case st.Running:
+		return "running"
+	case st.Fatal:
+		return "fatal"
+	case st.ManualStop:
+		return "stopped"
+	case st.Enable:
+		return "exited"
+	default:
+		return "disabled"
+	}
+}
+
+// String 单行的可读格式
+func (ru *ResourceUsage) String() string {
+	return fmt.Sprintf("state: %s  cpu: %.1f%%  rss: %s  threads: %d  fds: %d  procs: %d  uptime: %s",
+		ru.State, ru.CPUPercent, FormatBytes(ru.RSS), ru.Threads, ru.FDs, ru.Procs,
+		(time.Duration(ru.Uptime) * time.Second).String())
+}
+
+// ProcUsage 统计pid及其子进程的资源占用，cpu百分比由调用方根据两次采样计算
+func ProcUsage(main *ProcStat, children []*ProcStat) *ResourceUsage {
+	ru := &ResourceUsage{
+		Pid:   main.Pid,
+		State: string(main.State),
+	}
+	var ticks uint64
+	for _, p := range append([]*ProcStat{main}, children...) {
+		ru.Procs++
+		ru.Threads += p.Threads
+		ticks += p.Utime + p.Stime
+		ru.RSS += procRSS(p)
+		if fds, err := os.ReadDir("/proc/" + strconv.Itoa(p.Pid) + "/fd"); err == nil {
+			ru.FDs += len(fds)
+		}
+	}
+	ru.CPUSeconds = float64(ticks) / ClockTicks
+	if bt := BootTime(); bt > 0 {
+		ru.StartTime = bt + int64(main.StartTime/ClockTicks)
+		ru.Uptime = max(time.Now().Unix()-ru.StartTime, 0)
+	}
+	return ru
+}
+
+// procRSS 优先读取/proc/[pid]/status中的VmRSS
+func procRSS(p *ProcStat) uint64 {
+	f, err := os.Open("/proc/" + strconv.Itoa(p.Pid) + "/status")
+	if err == nil {
+		defer f.Close()
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			if v, ok := strings.CutPrefix(scanner.Text(), "VmRSS:"); ok {
+				kb, _ := strconv.ParseUint(strings.TrimSuffix(strings.TrimSpace(v), " kB"), 10, 64)
+				return kb * 1024
+			}
+		}
+	}
+	return p.RSS * uint64(os.Getpagesize())
+}
+
+// BootTime 系统启动时间，unix秒
+func BootTime() int64 {
+	f, err := os.Open("/proc/stat")
+	if err != nil {
+		return 0
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if v, ok := strings.CutPrefix(scanner.Text(), "btime "); ok {
+			bt, _ := strconv.ParseInt(strings.TrimSpace(v), 10, 64)
+			return bt
+		}
+	}
+	return 0
+}
+
+// FormatBytes 转换为KB/MB/GB
+func FormatBytes(b uint64) string {
+	switch {
+	case b >= 1<<30:
+		return fmt.Sprintf("%.1fG", float64(b)/(1<<30))
+	case b >= 1<<20:
+		return fmt.Sprintf("%.1fM", float64(b)/(1<<20))
+	case b >= 1<<10:
+		return fmt.Sprintf("%.1fK", float64(b)/(1<<10))
+	}
+	return strconv.FormatUint(b, 10) + "B"
+}
+
+// ProcNode 进程树节点
+type ProcNode struct {
+	Pid      int         `json:"pid"`
+	PPid     int         `json:"ppid"`
+	State    string      `json:"state"`
+	RSS      uint64      `json:"rss_bytes"`
+	CmdLine  string      `json:"cmdline"`
+	Children []*ProcNode `json:"children,omitempty"`
+}
+
+// BuildProcTree 以main为根，按ppid组织进程树，父进程不在members中的进程(如被重新挂载的孤儿)挂到根下
+func BuildProcTree(main *ProcStat, members []*ProcStat) *ProcNode {
+	nodes := make(map[int]*ProcNode, len(members)+1)
+	for _, p := range append([]*ProcStat{main}, members...) {
+		nodes[p.Pid] = &ProcNode{
+			Pid:     p.Pid,
+			PPid:    p.PPid,
+			State:   string(p.State),
+			RSS:     p.RSS * uint64(os.Getpagesize()),
+			CmdLine: procCmdLine(p),
+		}
+	}
+	root := nodes[main.Pid]
+	pids := make([]int, 0, len(members))
+	for _, p := range members {
+		pids = append(pids, p.Pid)
+	}
+	slices.Sort(pids)
+	for _, pid := range pids {
+		n := nodes[pid]
+		if parent, ok := nodes[n.PPid]; ok && n.PPid != pid {
+			parent.Children = append(parent.Children, n)
+		} else {
+			root.Children = append(root.Children, n)
+		}
+	}
+	return root
+}
+
+// Render 树形文本
+func (pn *ProcNode) Render() string {
+	ss := strings.Builder{}
+	pn.render(&ss, "", "")
+	return strings.TrimSuffix(ss.String(), "\n")
+}
+
+func (pn *ProcNode) render(ss *strings.Builder, prefix, childPrefix string) {
+	ss.WriteString(fmt.Sprintf("%s%d  %s  %s  %s\n", prefix, pn.Pid, pn.State, FormatBytes(pn.RSS), pn.CmdLine))
+	for i, c := range pn.Children {
+		if i == len(pn.Children)-1 {
+			c.render(ss, childPrefix+"└─ ", childPrefix+"   ")
+		} else {
+			c.render(ss, childPrefix+"├─ ", childPrefix+"│  ")
+		}
+	}
+}
+
+func procCmdLine(p *ProcStat) string {
+	b, _ := os.ReadFile("/proc/" + strconv.Itoa(p.Pid) + "/cmdline")
+	if s := strings.TrimSpace(strings.ReplaceAll(string(b), "\x00", " ")); s != "" {
+		return s
+	}
+	return "[" + p.Comm + "]"
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"os/exec"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	model "extsvr/model"
+)
+
+// 通知配置中的事件名和事件类型的对应关系
+var notifyKinds = map[string]string{
+	model.EventCrashed:       "crash",
+	model.EventRestarted:     "restart",
+	model.EventRestartFailed: "restart_failed",
+	model.EventFatal:         "fatal",
+	model.EventRecovered:     "recovery",
+}
+
+// notification 发送给webhook或命令的内容
+type notification struct {
+	Kind       string       `json:"kind"`
+	Host       string       `json:"host"`
+	Event      *model.Event `json:"event"`
+	Suppressed uint32       `json:"suppressed,omitempty"` // 上次发送后因限流或队列满丢弃的通知数量
+}
+
+type notifier struct {
+	*model.NotifierSettings
+	locker     sync.Mutex
+	window     time.Time
+	sent       uint32
+	suppressed uint32
+	queue      chan *notifyJob
+}
+
+type notifyJob struct {
+	n       *notification
+	attempt uint32
+}
+
+// startNotifiers 订阅事件，将服务的异常、重启、FATAL和恢复通知给服务notify中指定的通知方式
+func startNotifiers(ns []*model.NotifierSettings) {
+	all := make(map[string]*notifier)
+	for _, v := range ns {
+		if v.Name == "" || (v.Webhook == "") == (v.Command == "") {
+			stdlog.Error("notifier `" + v.Name + "` needs a name and one of webhook or command")
+			continue
+		}
+		if v.Rate == 0 {
+			v.Rate = 10
+		}
+		if v.Retry == 0 {
+			v.Retry = 3
+		}
+		n := &notifier{NotifierSettings: v, queue: make(chan *notifyJob, 256)}
+		all[v.Name] = n
+		go n.run()
+	}
+	if len(all) == 0 {
+		return
+	}
+	host, _ := os.Hostname()
+	// 通知不能像events订阅那样随意丢弃，使用更大的队列，仍然丢弃时计入所有通知方式的suppressed
+	sub := subscribeSize("notifier", "", 1024)
+	go func() {
+		for ev := range sub.ch {
+			if d := sub.dropped.Swap(0); d > 0 {
+				stdlog.Warning("notifier queue full, " + strconv.Itoa(int(d)) + " events dropped")
+				for _, n := range all {
+					n.locker.Lock()
+					n.suppressed += d
+					n.locker.Unlock()
+				}
+			}
+			kind, ok := notifyKinds[ev.Type]
+			if !ok {
+				continue
+			}
+			svr, ok := allconf.GetItem(ev.Service)
+			if !ok {
+				continue
+			}
+			for _, name := range svr.Notify {
+				n, ok := all[name]
+				if !ok {
+					stdlog.Warning(ev.Service + " notify `" + name + "` not found in settings")
+					continue
+				}
+				if len(n.Events) > 0 && !slices.Contains(n.Events, kind) {
+					continue
+				}
+				n.push(&notification{Kind: kind, Host: host, Event: ev})
+			}
+		}
+	}()
+}
+
+// push 限流后放入发送队列，每分钟超过rate的通知丢弃并计数，队列满时也丢弃
+func (n *notifier) push(x *notification) {
+	n.locker.Lock()
+	if time.Since(n.window) > time.Minute {
+		n.window = time.Now()
+		n.sent = 0
+	}
+	if n.sent >= n.Rate {
+		n.suppressed++
+		n.locker.Unlock()
+		return
+	}
+	n.sent++
+	x.Suppressed, n.suppressed = n.suppressed, 0
+	n.locker.Unlock()
+	n.enqueue(&notifyJob{n: x})
+}
+
+func (n *notifier) enqueue(j *notifyJob) {
+	select {
+	case n.queue <- j:
+	default:
+		stdlog.Warning("notifier " + n.Name + " queue full, drop " + j.n.Kind + " of " + j.n.Event.Service)
+	}
+}
+
+// run 依次发送，失败后按2,4,8...秒后重新放回队列，直到达到retry次数
+func (n *notifier) run() {
+	for j := range n.queue {
+		err := n.send(j.n)
+		if err == nil {
+			continue
+		}
+		j.attempt++
+		if j.attempt > n.Retry {
+			stdlog.Error("notifier " + n.Name + " give up " + j.n.Kind + " of " + j.n.Event.Service + ": " + err.Error())
+			continue
+		}
+		stdlog.Warning("notifier " + n.Name + " failed, retry " + strconv.Itoa(int(j.attempt)) + ": " + err.Error())
+		time.AfterFunc(time.Second<<j.attempt, func() { n.enqueue(j) })
+	}
+}
+
+func (n *notifier) send(x *notification) error {
+	b, _ := json.Marshal(x)
+	if n.Webhook != "" {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+		defer cancel()
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.Webhook, bytes.NewReader(b))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		for k, v := range n.Headers {
+			req.Header.Set(k, v)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode/100 != 2 {
+			return errors.New("webhook response " + resp.Status)
+		}
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*30)
+	defer cancel()
+	args, err := model.SplitArgs(n.Command)
+	if err != nil || len(args) == 0 {
+		return errors.New("bad command: " + n.Command)
+	}
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Stdin = bytes.NewReader(b)
+	out := &bytes.Buffer{}
+	cmd.Stdout, cmd.Stderr = out, out
+	if err := runHelper(cmd); err != nil {
+		return errors.New(err.Error() + " " + string(bytes.TrimSpace(out.Bytes())))
+	}
+	return nil
+}
+
+// svrCrashed 服务异常退出，重复调用只记录一次
+func svrCrashed(name string, pid int, msg string) {
+	svr, ok := allconf.GetItem(name)
+	if !ok || svr.Crashed {
+		return
+	}
+	_ = allconf.SetHealth(name, true, svr.Fails, svr.Fatal)
+	emit(model.NewEvent(model.EventCrashed, name, pid, msg))
+}
+
+// svrRestartFailed keepalive重启失败，连续失败达到fatal_after次后不再自动重启，直到手动start
+func svrRestartFailed(name string) {
+	svr, ok := allconf.GetItem(name)
+	if !ok {
+		return
+	}
+	fails := svr.Fails + 1
+	fatal := settings.FatalAfter > 0 && fails >= settings.FatalAfter
+	_ = allconf.SetHealth(name, true, fails, fatal)
+	if fatal {
+		emit(model.NewEvent(model.EventFatal, name, 0, strconv.Itoa(int(fails))+" restarts failed, stop retrying"))
+		stdlog.Error(name + " FATAL, " + strconv.Itoa(int(fails)) + " restarts failed, stop retrying")
+	}
+}
+
+// svrRecovered 启动成功后清除异常状态
+func svrRecovered(name string, pid int) {
+	svr, ok := allconf.GetItem(name)
+	if !ok || !svr.Crashed && !svr.Fatal && svr.Fails == 0 {
+		return
+	}
+	_ = allconf.SetHealth(name, false, 0, false)
+	emit(model.NewEvent(model.EventRecovered, name, pid, ""))
+}
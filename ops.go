@@ -0,0 +1,64 @@
+package main
+
+import (
+	"sync"
+
+	model "extsvr/model"
+)
+
+// 同一服务的启动、停止等操作串行执行，不同服务的操作互不影响，
+// 重新加载配置等涉及所有服务的操作等待其他操作完成后独占执行
+var (
+	opsLocker  sync.RWMutex
+	svrLockers sync.Map // 服务名 -> *sync.Mutex
+)
+
+func svrLocker(name string) *sync.Mutex {
+	l, _ := svrLockers.LoadOrStore(name, &sync.Mutex{})
+	return l.(*sync.Mutex)
+}
+
+// lockSvr 锁定服务，返回解锁函数
+func lockSvr(name string) func() {
+	opsLocker.RLock()
+	l := svrLocker(name)
+	l.Lock()
+	return func() {
+		l.Unlock()
+		opsLocker.RUnlock()
+	}
+}
+
+// tryLockSvr 服务正在被操作时返回false，keepalive用于跳过该服务
+func tryLockSvr(name string) (func(), bool) {
+	if !opsLocker.TryRLock() {
+		return nil, false
+	}
+	l := svrLocker(name)
+	if !l.TryLock() {
+		opsLocker.RUnlock()
+		return nil, false
+	}
+	return func() {
+		l.Unlock()
+		opsLocker.RUnlock()
+	}, true
+}
+
+// lockAll 等待所有服务的操作完成，返回解锁函数
+func lockAll() func() {
+	opsLocker.Lock()
+	return opsLocker.Unlock
+}
+
+// startSvr 锁定服务后启动
+func startSvr(name string, svr *model.ServiceParams) (string, bool) {
+	defer lockSvr(name)()
+	return startSvrFork(name, svr)
+}
+
+// stopSvr 锁定服务后停止
+func stopSvr(name string, svr *model.ServiceParams) (string, bool) {
+	defer lockSvr(name)()
+	return stopSvrFork(name, svr)
+}
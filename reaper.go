@@ -0,0 +1,236 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	model "extsvr/model"
+)
+
+const prSetChildSubreaper = 36
+
+// 记录由ssdctld启动的主进程，以及曾经观察到的后代进程归属，
+// 用于识别已经脱离进程组(setsid)后被重新挂到ssdctld下的孤儿进程
+var (
+	ownerLocker sync.Mutex
+	owners      = make(map[int]string)
+	mains       = make(map[int]string)
+)
+
+// 由exec.Cmd.Wait等待的辅助命令(replace和notify)，reapChildren不收割它们
+var (
+	helperLocker sync.Mutex
+	helpers      = make(map[int]bool)
+)
+
+// runHelper 在独立的进程组中执行辅助命令并等待，ctx取消时杀死整个进程组，结束后清理留下的后代进程，
+// 启动和登记都在helperLocker中完成，reapChildren不会抢走它的退出状态
+func runHelper(cmd *exec.Cmd) error {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+	// 后代进程持有输出管道时不会一直等待
+	cmd.WaitDelay = time.Second
+	helperLocker.Lock()
+	err := cmd.Start()
+	if err == nil {
+		helpers[cmd.Process.Pid] = true
+	}
+	helperLocker.Unlock()
+	if err != nil {
+		return err
+	}
+	pid := cmd.Process.Pid
+	err = cmd.Wait()
+	if errors.Is(err, exec.ErrWaitDelay) { // 命令已经成功退出，后台进程仍持有输出管道，使用已经读到的输出
+		err = nil
+	}
+	_ = syscall.Kill(-pid, syscall.SIGKILL)
+	helperLocker.Lock()
+	delete(helpers, pid)
+	helperLocker.Unlock()
+	return err
+}
+
+// trackMain 记录启动的服务主进程，收割时即使服务已被标记为停止也能记录退出码
+func trackMain(pid int, name string) {
+	ownerLocker.Lock()
+	mains[pid] = name
+	ownerLocker.Unlock()
+}
+
+// setSubreaper 设置PR_SET_CHILD_SUBREAPER，服务的孙进程在父进程退出后会挂到ssdctld下，而不是init
+func setSubreaper() error {
+	_, _, e := syscall.RawSyscall(syscall.SYS_PRCTL, prSetChildSubreaper, 1, 0)
+	if e != 0 {
+		return e
+	}
+	return nil
+}
+
+// reapLoop 收到SIGCHLD后收割僵尸子进程
+func reapLoop() {
+	ch := make(chan os.Signal, 16)
+	signal.Notify(ch, syscall.SIGCHLD)
+	reapChildren()
+	for range ch {
+		reapChildren()
+	}
+}
+
+// reapChildren 收割所有僵尸子进程，包括挂到ssdctld下的孤儿，
+// runHelper执行的辅助命令由各自的exec.Cmd.Wait处理，避免抢走它们的退出状态
+func reapChildren() {
+	self := os.Getpid()
+	procs := model.ScanProcs()
+	for _, p := range procs {
+		if p.PPid != self || p.State != 'Z' {
+			continue
+		}
+		name, main := svrOwner(p, procs)
+		var ws syscall.WaitStatus
+		pid, err := waitChild(p.Pid, &ws)
+		if err != nil || pid != p.Pid {
+			continue
+		}
+		forgetOwner(p.Pid)
+		switch {
+		case main:
+			_ = allconf.SetExit(name, exitCode(ws))
+			emit(model.NewEvent(model.EventExited, name, pid, waitStatusString(ws)).WithCode(exitCode(ws)))
+			if svr, ok := allconf.GetItem(name); ok && svr.Pid == pid && !svr.ManualStop {
+				svrCrashed(name, pid, waitStatusString(ws))
+			}
+			stdlog.Warning(name + " exited, " + waitStatusString(ws) + ", PID: " + strconv.Itoa(pid))
+		case name != "":
+			stdlog.Info("reaped orphan of " + name + ", " + waitStatusString(ws) + ", PID: " + strconv.Itoa(pid))
+		default:
+			stdlog.Info("reaped orphan, " + waitStatusString(ws) + ", PID: " + strconv.Itoa(pid))
+		}
+	}
+}
+
+// waitChild 收割pid，runHelper正在等待的辅助命令不收割
+func waitChild(pid int, ws *syscall.WaitStatus) (int, error) {
+	helperLocker.Lock()
+	defer helperLocker.Unlock()
+	if helpers[pid] {
+		return 0, nil
+	}
+	return syscall.Wait4(pid, ws, syscall.WNOHANG, nil)
+}
+
+// exitCode 被信号杀死时按shell的习惯返回128+信号
+func exitCode(ws syscall.WaitStatus) int {
+	if ws.Signaled() {
+		return 128 + int(ws.Signal())
+	}
+	return ws.ExitStatus()
+}
+
+func waitStatusString(ws syscall.WaitStatus) string {
+	if ws.Signaled() {
+		return "signal: " + ws.Signal().String()
+	}
+	return "exit code: " + strconv.Itoa(ws.ExitStatus())
+}
+
+// svrOwner 根据pgid或记录的归属找到进程所属的服务，main表示该进程就是服务的主进程
+func svrOwner(p *model.ProcStat, procs map[int]*model.ProcStat) (string, bool) {
+	ownerLocker.Lock()
+	if s, ok := mains[p.Pid]; ok {
+		ownerLocker.Unlock()
+		return s, true
+	}
+	ownerLocker.Unlock()
+	var name string
+	var main bool
+	allconf.ForEach(func(key string, value *model.ServiceParams) bool {
+		if value.Pid <= 0 {
+			return true
+		}
+		if value.Pid == p.Pid {
+			name, main = key, true
+			return false
+		}
+		if p.Pgid == value.Pid || p.Pgid == svrPgid(value.Pid, procs) {
+			name = key
+			return false
+		}
+		return true
+	})
+	if name != "" {
+		return name, main
+	}
+	ownerLocker.Lock()
+	defer ownerLocker.Unlock()
+	for _, id := range []int{p.Pid, p.Pgid, p.Sid} {
+		if s, ok := owners[id]; ok {
+			return s, false
+		}
+	}
+	return "", false
+}
+
+func svrPgid(pid int, procs map[int]*model.ProcStat) int {
+	if p, ok := procs[pid]; ok {
+		return p.Pgid
+	}
+	return 0
+}
+
+func forgetOwner(pid int) {
+	ownerLocker.Lock()
+	delete(owners, pid)
+	delete(mains, pid)
+	ownerLocker.Unlock()
+}
+
+// svrChildren 返回服务主进程之外属于该服务的进程：主进程的后代、同进程组的进程，以及被重新挂到ssdctld下的孤儿
+func svrChildren(name string, pid int, procs map[int]*model.ProcStat) []*model.ProcStat {
+	self := os.Getpid()
+	pgid := svrPgid(pid, procs)
+	ss := make([]*model.ProcStat, 0)
+	ownerLocker.Lock()
+	defer ownerLocker.Unlock()
+	for _, p := range procs {
+		if p.Pid == pid {
+			continue
+		}
+		found := pgid > 0 && p.Pgid == pgid
+		if !found {
+			for x := procs[p.PPid]; x != nil && x.Pid > 1; x = procs[x.PPid] {
+				if x.Pid == pid {
+					found = true
+					break
+				}
+			}
+		}
+		if !found && p.PPid == self {
+			for _, id := range []int{p.Pid, p.Pgid, p.Sid} {
+				if owners[id] == name {
+					found = true
+					break
+				}
+			}
+		}
+		if found {
+			owners[p.Pid] = name
+			ss = append(ss, p)
+		}
+	}
+	// 清理已经不存在的记录
+	for k := range owners {
+		if _, ok := procs[k]; !ok {
+			delete(owners, k)
+		}
+	}
+	return ss
+}
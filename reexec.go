@@ -0,0 +1,210 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+
+	model "extsvr/model"
+)
+
+// 重新执行时通过该环境变量把运行时状态交给新的进程
+const reexecEnv = "SSDCTLD_REEXEC"
+
+// handoff 重新执行时交给新进程的状态，文件描述符在exec后保持打开，
+// 服务的pid和手动停止等状态已经写入pid.d和状态文件
+type handoff struct {
+	Stream   int            `json:"stream,omitempty"`   // 流协议socket
+	Dgram    int            `json:"dgram,omitempty"`    // 数据报socket
+	Consoles map[string]int `json:"consoles,omitempty"` // 服务名 -> pty master
+	Mains    map[int]string `json:"mains,omitempty"`
+	Owners   map[int]string `json:"owners,omitempty"`
+
+	conns []syscall.RawConn
+}
+
+// inherited 从旧进程接收的状态，不是重新执行启动时为nil
+var inherited *handoff
+
+// reexec 保存状态后用当前的可执行文件替换自身，pid不变，服务仍是ssdctld的子进程，
+// 成功时不会返回，失败时恢复运行
+func reexec(cli *unixClient, todo *model.ToDo) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	if err := syscall.Access(exe, 1); err != nil { // X_OK
+		return errors.New(exe + ": " + err.Error())
+	}
+	if shuttingDown.Swap(true) {
+		return errors.New("ssdctld is shutting down")
+	}
+	unlock := lockAll()
+	h, err := newHandoff()
+	if err != nil {
+		h.release()
+		unlock()
+		shuttingDown.Store(false)
+		return err
+	}
+	syncPidFiles()
+	if err := allconf.SaveState(); err != nil {
+		stdlog.Error("save state error: " + err.Error())
+	}
+	b, _ := json.Marshal(h)
+	env := slices.DeleteFunc(os.Environ(), func(s string) bool {
+		return strings.HasPrefix(s, reexecEnv+"=")
+	})
+	env = append(env, reexecEnv+"="+string(b))
+	cli.Reply("", model.CodeOK, formatOutput("", "REEXEC", exe), nil)
+	audit(cli, todo) // 成功时不会执行defer
+	stdlog.Warning("reexec " + exe)
+	err = syscall.Exec(exe, os.Args, env)
+	stdlog.Error("reexec error: " + err.Error())
+	h.release()
+	unlock()
+	shuttingDown.Store(false)
+	return err
+}
+
+// newHandoff 收集需要交给新进程的状态，并去掉socket和pty的FD_CLOEXEC
+func newHandoff() (*handoff, error) {
+	h := &handoff{
+		Consoles: make(map[string]int),
+		Mains:    make(map[int]string),
+		Owners:   make(map[int]string),
+	}
+	var err error
+	if streamLn != nil {
+		if h.Stream, err = h.inherit(streamLn); err != nil {
+			return h, err
+		}
+	}
+	if uln != nil {
+		if h.Dgram, err = h.inherit(uln); err != nil {
+			return h, err
+		}
+	}
+	consoleLocker.RLock()
+	for name, c := range consoles {
+		if h.Consoles[name], err = h.inherit(c.master); err != nil {
+			break
+		}
+	}
+	consoleLocker.RUnlock()
+	if err != nil {
+		return h, err
+	}
+	ownerLocker.Lock()
+	for k, v := range mains {
+		h.Mains[k] = v
+	}
+	for k, v := range owners {
+		h.Owners[k] = v
+	}
+	ownerLocker.Unlock()
+	return h, nil
+}
+
+// inherit 去掉FD_CLOEXEC，返回文件描述符
+func (h *handoff) inherit(sc syscall.Conn) (int, error) {
+	rc, err := sc.SyscallConn()
+	if err != nil {
+		return 0, err
+	}
+	fd, err := setCloexec(rc, false)
+	if err == nil {
+		h.conns = append(h.conns, rc)
+	}
+	return fd, err
+}
+
+// release exec失败时恢复FD_CLOEXEC，避免之后启动的服务继承这些文件描述符
+func (h *handoff) release() {
+	for _, rc := range h.conns {
+		setCloexec(rc, true)
+	}
+	h.conns = nil
+}
+
+func setCloexec(rc syscall.RawConn, on bool) (int, error) {
+	var fd int
+	var serr error
+	flag := 0
+	if on {
+		flag = syscall.FD_CLOEXEC
+	}
+	err := rc.Control(func(x uintptr) {
+		fd = int(x)
+		if _, _, e := syscall.Syscall(syscall.SYS_FCNTL, x, syscall.F_SETFD, uintptr(flag)); e != 0 {
+			serr = e
+		}
+	})
+	if err != nil {
+		return 0, err
+	}
+	return fd, serr
+}
+
+// loadHandoff 读取旧进程交接的状态，需要在启动任何服务之前调用，避免服务继承该环境变量
+func loadHandoff() {
+	s, ok := os.LookupEnv(reexecEnv)
+	if !ok {
+		return
+	}
+	os.Unsetenv(reexecEnv)
+	h := &handoff{}
+	if err := json.Unmarshal([]byte(s), h); err != nil {
+		stdlog.Error("load reexec state error: " + err.Error())
+		return
+	}
+	inherited = h
+	ownerLocker.Lock()
+	for k, v := range h.Mains {
+		mains[k] = v
+	}
+	for k, v := range h.Owners {
+		owners[k] = v
+	}
+	ownerLocker.Unlock()
+	for name, fd := range h.Consoles {
+		syscall.CloseOnExec(fd)
+		startConsole(name, os.NewFile(uintptr(fd), "/dev/ptmx"))
+	}
+	stdlog.Warning("resumed after reexec, " + strconv.Itoa(len(h.Mains)) + " processes, " + strconv.Itoa(len(h.Consoles)) + " consoles")
+}
+
+// inheritedStream 旧进程的流协议socket，只能取一次
+func inheritedStream() (*net.UnixListener, error) {
+	if inherited == nil || inherited.Stream == 0 {
+		return nil, nil
+	}
+	f := os.NewFile(uintptr(inherited.Stream), "stream")
+	inherited.Stream = 0
+	defer f.Close()
+	ln, err := net.FileListener(f)
+	if err != nil {
+		return nil, err
+	}
+	return ln.(*net.UnixListener), nil
+}
+
+// inheritedDgram 旧进程的数据报socket，只能取一次
+func inheritedDgram() (*net.UnixConn, error) {
+	if inherited == nil || inherited.Dgram == 0 {
+		return nil, nil
+	}
+	f := os.NewFile(uintptr(inherited.Dgram), "dgram")
+	inherited.Dgram = 0
+	defer f.Close()
+	c, err := net.FilePacketConn(f)
+	if err != nil {
+		return nil, err
+	}
+	return c.(*net.UnixConn), nil
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+	"unsafe"
+
+	model "extsvr/model"
+)
+
+// reloadConfig 重新读取cnf.d并和当前配置比较，apply为true时应用新的配置，
+// 并按设置停止删除的服务、启动新增的服务、重启on_config_change为restart的服务，
+// 每个操作的结果通过report返回，apply为true时调用方需要持有lockAll
+func reloadConfig(apply bool, report func(name, s string, ok bool)) ([]*model.ServiceDiff, []*model.ConfigError) {
+	data, errs := allconf.ReadFiles()
+	ds := model.DiffServices(allconf.Snapshot(), data)
+	planReload(ds)
+	if !apply {
+		return ds, errs
+	}
+	for _, d := range ds {
+		if d.Action == model.NameStop {
+			s, ok := stopSvrFork(d.Name, d.Old)
+			report(d.Name, s, ok)
+		}
+	}
+	allconf.Replace(data)
+	// start_new为false时新服务不启动，标记为手动停止，keepalive不会把它们当作异常退出，需要ssdctl start
+	for _, d := range ds {
+		if d.Change == model.ChangeAdded && d.Action == "" && d.New.Enable {
+			_ = allconf.SetRuntime(d.Name, 0, true)
+		}
+	}
+	// 按优先级启动
+	starts := slices.DeleteFunc(slices.Clone(ds), func(d *model.ServiceDiff) bool {
+		return d.Action != model.NameStart && d.Action != model.NameRestart
+	})
+	slices.SortStableFunc(starts, func(a, b *model.ServiceDiff) int {
+		return int(a.New.Priority) - int(b.New.Priority)
+	})
+	for _, d := range starts {
+		if d.Action == model.NameRestart {
+			s, ok := stopSvrFork(d.Name, d.Old)
+			report(d.Name, s, ok)
+			if !ok {
+				continue
+			}
+		}
+		svr, _ := allconf.GetItem(d.Name)
+		s, ok := startSvrFork(d.Name, svr)
+		if !ok && d.Action == model.NameRestart { // 启动失败时交给keepalive重试
+			_ = allconf.SetRuntime(d.Name, 0, false)
+		}
+		report(d.Name, s, ok)
+	}
+	return ds, errs
+}
+
+// planReload 根据设置和服务状态决定每个变化需要执行的操作
+func planReload(ds []*model.ServiceDiff) {
+	rs := settings.Reload
+	if rs == nil {
+		rs = &model.ReloadSettings{}
+	}
+	for _, d := range ds {
+		switch d.Change {
+		case model.ChangeAdded:
+			if rs.StartNew && d.New.Enable {
+				d.Action = model.NameStart
+			}
+		case model.ChangeRemoved:
+			if !rs.StopRemoved {
+				continue
+			}
+			if _, _, ok := svrIsRunning(d.Old); ok {
+				d.Action = model.NameStop
+			}
+		case model.ChangeChanged:
+			if d.New.OnConfigChange != model.OnChangeRestart || d.Old.ManualStop {
+				continue
+			}
+			if _, _, ok := svrIsRunning(d.Old); ok {
+				d.Action = model.NameRestart
+			}
+		}
+	}
+}
+
+// reloadSummary 配置变化和计划执行的操作
+func reloadSummary(ds []*model.ServiceDiff) string {
+	ss := make([]string, 0, len(ds))
+	for _, d := range ds {
+		s := d.String()
+		if d.Action != "" {
+			s += " -> " + d.Action
+		}
+		switch d.Change {
+		case model.ChangeAdded:
+			ss = append(ss, "+ "+s)
+		case model.ChangeRemoved:
+			ss = append(ss, "- "+s)
+		default:
+			ss = append(ss, "* "+s)
+		}
+	}
+	if len(ss) == 0 {
+		return "no changes"
+	}
+	return strings.Join(ss, "\n")
+}
+
+// autoReload 监视到cnf.d变化后重新加载
+func autoReload() {
+	unlock := lockAll()
+	ds, errs := reloadConfig(true, func(name, s string, ok bool) {
+		stdlog.Info(s)
+	})
+	unlock()
+	logConfigErrors(errs)
+	if len(ds) == 0 {
+		return
+	}
+	stdlog.Info("cnf.d changed, reload:\n" + reloadSummary(ds))
+	emit(model.NewEvent(model.EventReloaded, "", 0, strconv.Itoa(len(ds))+" services changed"))
+}
+
+// watchConfig 使用inotify监视cnf.d中的yaml文件，最后一次变化debounce秒后重新加载
+func watchConfig(debounce time.Duration) error {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC)
+	if err != nil {
+		return err
+	}
+	_, err = syscall.InotifyAddWatch(fd, cnfdir, syscall.IN_CLOSE_WRITE|syscall.IN_MOVED_TO|syscall.IN_MOVED_FROM|syscall.IN_DELETE)
+	if err != nil {
+		syscall.Close(fd)
+		return err
+	}
+	stdlog.Info("watching " + cnfdir)
+	go func() {
+		defer syscall.Close(fd)
+		var t *time.Timer
+		buf := make([]byte, 64*1024)
+		for {
+			n, err := syscall.Read(fd, buf)
+			if err != nil {
+				if err == syscall.EINTR {
+					continue
+				}
+				stdlog.Error("watch " + cnfdir + " error: " + err.Error())
+				return
+			}
+			changed := false
+			for i := 0; i+syscall.SizeofInotifyEvent <= n; {
+				ev := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[i]))
+				name := strings.TrimRight(string(buf[i+syscall.SizeofInotifyEvent:i+syscall.SizeofInotifyEvent+int(ev.Len)]), "\x00")
+				i += syscall.SizeofInotifyEvent + int(ev.Len)
+				if ev.Mask&syscall.IN_IGNORED != 0 { // cnf.d被删除或移走
+					stdlog.Error("stop watching " + cnfdir + ", it was removed")
+					return
+				}
+				// 编辑器的临时文件不触发
+				if ev.Mask&syscall.IN_Q_OVERFLOW != 0 || filepath.Ext(name) == ".yaml" && !strings.HasPrefix(name, ".") {
+					changed = true
+				}
+			}
+			if !changed {
+				continue
+			}
+			if t == nil {
+				t = time.AfterFunc(debounce, autoReload)
+			} else {
+				t.Reset(debounce)
+			}
+		}
+	}()
+	return nil
+}
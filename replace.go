@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	model "extsvr/model"
+)
+
+type replaceValue struct {
+	value  string
+	expire time.Time
+}
+
+var (
+	replLocker sync.Mutex
+	replCache  = make(map[string]*replaceValue)
+)
+
+// resolveReplace 执行replace中的命令，返回参数替换器，失败且没有default时返回错误
+func resolveReplace(name string, svr *model.ServiceParams, env []string) (*strings.Replacer, error) {
+	if len(svr.Replace) == 0 {
+		return strings.NewReplacer(), nil
+	}
+	lookup := envLookup(env)
+	xss := make([]string, 0, len(svr.Replace)*2)
+	for _, rv := range svr.Replace {
+		if err := rv.Check(); err != nil {
+			return nil, err
+		}
+		v, err := replaceValueOf(name, &rv, env, svr.Dir, lookup)
+		if err != nil {
+			if rv.Default == nil {
+				return nil, errors.New("replace `" + rv.Key + "` failed: " + err.Error())
+			}
+			stdlog.Warning(name + " replace `" + rv.Key + "` failed, use default value: " + err.Error())
+			v = *rv.Default
+		}
+		xss = append(xss, rv.Key, v)
+	}
+	return strings.NewReplacer(xss...), nil
+}
+
+func replaceValueOf(name string, rv *model.ReplaceVar, env []string, dir string, lookup func(string) (string, bool)) (string, error) {
+	cmdline := model.ExpandEnv(rv.Cmd, lookup)
+	ck := name + "\x00" + rv.Key + "\x00" + cmdline
+	if rv.Cache > 0 {
+		replLocker.Lock()
+		c, ok := replCache[ck]
+		replLocker.Unlock()
+		if ok && time.Now().Before(c.expire) {
+			return c.value, nil
+		}
+	}
+	args, err := model.SplitArgs(cmdline)
+	if err != nil {
+		return "", err
+	}
+	if len(args) == 0 {
+		return "", errors.New("empty command")
+	}
+	timeout := time.Second * 10
+	if rv.Timeout > 0 {
+		timeout = time.Second * time.Duration(rv.Timeout)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Dir = dir
+	cmd.Env = env
+	out := &bytes.Buffer{}
+	cmd.Stdout = out
+	err = runHelper(cmd)
+	b := out.Bytes()
+	if ctx.Err() != nil {
+		return "", errors.New("timeout after " + timeout.String())
+	}
+	if err != nil {
+		return "", err
+	}
+	v := strings.TrimSpace(string(b))
+	if rv.Cache > 0 {
+		replLocker.Lock()
+		replCache[ck] = &replaceValue{
+			value:  v,
+			expire: time.Now().Add(time.Second * time.Duration(rv.Cache)),
+		}
+		replLocker.Unlock()
+	}
+	return v, nil
+}
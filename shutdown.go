@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"syscall"
+
+	model "extsvr/model"
+)
+
+var shuttingDown atomic.Bool
+
+// handleSignals 收到SIGTERM/SIGINT时和ssdctl shutdown --leave-running一样退出，console服务同样不能保留
+func handleSignals() {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGTERM, syscall.SIGINT)
+	go func() {
+		s := <-ch
+		stdlog.Warning("received " + s.String() + ", shutdown")
+		if shutdown(false, nil) {
+			app.Exit(0)
+		}
+	}()
+}
+
+// shutdown 等待进行中的操作完成后不再接受新的操作，stopServices为true时按优先级倒序停止所有服务，
+// 之后保存状态、整理pid文件，由调用方退出，已经在退出时返回false。
+// 不停止服务时，console服务的伪终端随ssdctld退出而关闭，会收到SIGHUP，只有reexec能保留它们
+func shutdown(stopServices bool, cli *unixClient) bool {
+	if shuttingDown.Swap(true) {
+		return false
+	}
+	lockAll() // 不再解锁，keepalive和其他请求都会跳过或等待
+	if stopServices {
+		ss := make([]*model.ServiceParams, 0)
+		names := make([]string, 0)
+		allconf.ForEach(func(key string, value *model.ServiceParams) bool {
+			ss = append(ss, value)
+			names = append(names, key)
+			return true
+		})
+		slices.Reverse(ss)
+		slices.Reverse(names)
+		for i, svr := range ss {
+			if _, _, ok := svrIsRunning(svr); !ok {
+				continue
+			}
+			s, ok := stopSvrFork(names[i], svr)
+			stdlog.Warning(s)
+			// 恢复手动停止标记，ssdctld再次启动后按原来的状态拉起服务
+			_ = allconf.SetRuntime(names[i], 0, svr.ManualStop)
+			if cli != nil {
+				cli.Reply(names[i], resultCode(ok), s, nil)
+			}
+		}
+	}
+	if !stopServices {
+		warnConsoles(cli)
+	}
+	syncPidFiles()
+	if err := allconf.SaveState(); err != nil {
+		stdlog.Error("save state error: " + err.Error())
+	}
+	if auditFile != nil {
+		auditFile.Sync()
+	}
+	stdlog.Warning("ssdctld shutdown")
+	return true
+}
+
+// syncPidFiles 删除不在运行的服务和未知服务的pid文件，运行中的服务重新写入当前的pid
+func syncPidFiles() {
+	running := make(map[string]int)
+	allconf.ForEach(func(key string, value *model.ServiceParams) bool {
+		if pid, _, ok := svrIsRunning(value); ok {
+			running[key] = pid
+		}
+		return true
+	})
+	fs, _ := os.ReadDir(piddir)
+	for _, f := range fs {
+		name, ok := strings.CutSuffix(f.Name(), ".pid")
+		if !ok {
+			continue
+		}
+		if _, found := running[name]; !found {
+			os.Remove(filepath.Join(piddir, f.Name()))
+		}
+	}
+	for name, pid := range running {
+		os.WriteFile(filepath.Join(piddir, name+".pid"), fmt.Appendf([]byte{}, "%d", pid), 0o664)
+	}
+}
+
+// warnConsoles 提示运行中的console服务会随ssdctld退出收到SIGHUP
+func warnConsoles(cli *unixClient) {
+	names := make([]string, 0)
+	allconf.ForEach(func(key string, value *model.ServiceParams) bool {
+		if _, ok := getConsole(key); ok {
+			if _, _, running := svrIsRunning(value); running {
+				names = append(names, key)
+			}
+		}
+		return true
+	})
+	if len(names) == 0 {
+		return
+	}
+	s := "console programs `" + strings.Join(names, "`, `") + "` lose their terminal and get SIGHUP, use 'ssdctl daemon reexec' to upgrade without stopping them"
+	stdlog.Warning(s)
+	if cli != nil {
+		cli.Reply("", model.CodeOK, formatOutput("", "SHUTDOWN", s), nil)
+	}
+}
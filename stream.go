@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	model "extsvr/model"
+)
+
+// peer 请求的发送方，可以是旧版本的数据报客户端或流连接上的一个请求
+type peer interface {
+	// Name 客户端标识，用于订阅事件和console
+	Name() string
+	// Write 发送输出
+	Write(b []byte) error
+	// End 当前请求结束
+	End() error
+}
+
+// dgramPeer 数据报客户端，以"END"表示结束
+type dgramPeer struct {
+	addr *net.UnixAddr
+}
+
+func (p *dgramPeer) Name() string {
+	return p.addr.Name
+}
+
+func (p *dgramPeer) Write(b []byte) error {
+	_, err := uln.WriteToUnix(b, p.addr)
+	return err
+}
+
+func (p *dgramPeer) End() error {
+	return p.Write([]byte("END"))
+}
+
+var (
+	dgramLocker sync.Mutex
+	dgramQueues = make(map[string]chan *unixClient)
+)
+
+// dispatchDgram 不同客户端的请求并发处理，同一客户端的请求按顺序处理，
+// 旧版本客户端依赖END在之前的输出之后到达，空闲10秒后结束该客户端的处理协程
+func dispatchDgram(cli *unixClient, handle func(cli *unixClient)) {
+	name := cli.Name()
+	dgramLocker.Lock()
+	defer dgramLocker.Unlock()
+	q, ok := dgramQueues[name]
+	if !ok {
+		q = make(chan *unixClient, 64)
+		dgramQueues[name] = q
+		go func() {
+			for {
+				select {
+				case c := <-q:
+					handle(c)
+				case <-time.After(time.Second * 10):
+					dgramLocker.Lock()
+					if len(q) == 0 {
+						delete(dgramQueues, name)
+						dgramLocker.Unlock()
+						return
+					}
+					dgramLocker.Unlock()
+				}
+			}
+		}()
+	}
+	select {
+	case q <- cli:
+	default:
+		stdlog.Warning("too many requests from " + name + ", drop")
+	}
+}
+
+// streamConn 流连接，写入需要加锁，避免console和事件的输出与回复交错
+type streamConn struct {
+	locker sync.Mutex
+	conn   *net.UnixConn
+	name   string
+}
+
+func (sc *streamConn) write(f *model.Frame) error {
+	sc.locker.Lock()
+	defer sc.locker.Unlock()
+	sc.conn.SetWriteDeadline(time.Now().Add(time.Second * 10))
+	return model.WriteFrame(sc.conn, f)
+}
+
+// streamPeer 流连接上的一个请求
+type streamPeer struct {
+	sc   *streamConn
+	id   uint64
+	done atomic.Bool
+}
+
+func (p *streamPeer) Name() string {
+	return p.sc.name
+}
+
+func (p *streamPeer) Write(b []byte) error {
+	return p.sc.write(&model.Frame{ID: p.id, Kind: model.FrameOutput, Data: b})
+}
+
+func (p *streamPeer) reply(r *model.Response) error {
+	return p.sc.write(&model.Frame{ID: p.id, Kind: model.FrameResponse, Response: r})
+}
+
+// End 每个请求只发送一次完成帧
+func (p *streamPeer) End() error {
+	if p.done.Swap(true) {
+		return nil
+	}
+	return p.sc.write(&model.Frame{ID: p.id, Kind: model.FrameDone})
+}
+
+var (
+	streamID atomic.Uint64
+	streamLn *net.UnixListener
+)
+
+// listenStream 监听流协议，每个连接一个协程，不同连接的请求并发处理
+func listenStream(handle func(cli *unixClient)) error {
+	ln, err := inheritedStream()
+	if ln == nil && err == nil {
+		ln, err = net.ListenUnix("unix", model.StreamAddr)
+	}
+	if err != nil {
+		return err
+	}
+	streamLn = ln
+	stdlog.Info("start receiving from unix socket:" + model.StreamSock)
+	go func() {
+		for {
+			conn, err := ln.AcceptUnix()
+			if err != nil {
+				stdlog.Error("accept from unix socket error: " + err.Error())
+				time.Sleep(time.Second)
+				continue
+			}
+			go serveStream(conn, handle)
+		}
+	}()
+	return nil
+}
+
+// serveStream 依次处理连接上的请求，保证console输入的顺序，除attach和events外处理完立即发送完成帧，
+// 连接断开时取消该连接的事件订阅和console
+func serveStream(conn *net.UnixConn, handle func(cli *unixClient)) {
+	sc := &streamConn{
+		conn: conn,
+		name: "stream:" + strconv.FormatUint(streamID.Add(1), 10),
+	}
+	cred := peerCred(conn)
+	defer func() {
+		unsubscribe(sc.name)
+		detachConsoles(sc.name)
+		conn.Close()
+	}()
+	r := bufio.NewReader(conn)
+	for {
+		b, err := model.ReadFrame(r)
+		if err != nil {
+			return
+		}
+		x := &struct {
+			ID uint64 `json:"id"`
+		}{}
+		json.Unmarshal(b, x)
+		cli := &unixClient{
+			peer: &streamPeer{sc: sc, id: x.ID},
+			buf:  b,
+			cred: cred,
+		}
+		handle(cli)
+		if !cli.keep {
+			cli.End()
+		}
+	}
+}
+
+// peerCred 通过SO_PEERCRED获取连接另一端的身份
+func peerCred(conn *net.UnixConn) *syscall.Ucred {
+	rc, err := conn.SyscallConn()
+	if err != nil {
+		return nil
+	}
+	var cred *syscall.Ucred
+	rc.Control(func(fd uintptr) {
+		cred, _ = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	})
+	return cred
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	model "extsvr/model"
+)
+
+type cpuSample struct {
+	pid     int
+	seconds float64
+	at      time.Time
+}
+
+var (
+	sampleLocker sync.Mutex
+	cpuSamples   = make(map[string]*cpuSample)
+)
+
+// svrUsage 统计服务进程组的资源占用，cpu百分比为距上次采样的平均值，首次采样时为启动以来的平均值
+func svrUsage(name string, pid int, children []*model.ProcStat, procs map[int]*model.ProcStat) *model.ResourceUsage {
+	p, ok := procs[pid]
+	if !ok {
+		return nil
+	}
+	ru := model.ProcUsage(p, children)
+	now := time.Now()
+	sampleLocker.Lock()
+	defer sampleLocker.Unlock()
+	if last, ok := cpuSamples[name]; ok && last.pid == pid && now.Sub(last.at) > time.Millisecond*100 {
+		ru.CPUPercent = (ru.CPUSeconds - last.seconds) / now.Sub(last.at).Seconds() * 100
+	} else if ru.Uptime > 0 {
+		ru.CPUPercent = ru.CPUSeconds / float64(ru.Uptime) * 100
+	}
+	ru.CPUPercent = max(ru.CPUPercent, 0)
+	cpuSamples[name] = &cpuSample{
+		pid:     pid,
+		seconds: ru.CPUSeconds,
+		at:      now,
+	}
+	return ru
+}